@@ -2,7 +2,6 @@ package main
 
 import (
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/csv"
 	"fmt"
 	"os"
@@ -11,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/jeeruff/alf/internal/audio"
 )
 
 var blocks = []rune("▁▂▃▄▅▆▇█")
@@ -19,6 +20,7 @@ var audioExt = map[string]bool{
 	".wav": true, ".mp3": true, ".flac": true, ".ogg": true,
 	".aif": true, ".aiff": true, ".opus": true, ".m4a": true,
 	".wma": true, ".ape": true, ".wv": true, ".alac": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true,
 }
 
 type Meta struct {
@@ -30,8 +32,13 @@ type Meta struct {
 	Rate     string
 	Bits     string
 	Spark    string
+	Backend  string // audio backend that decoded the file, or noBackend
 }
 
+// noBackend is the Backend of a file nothing could decode, so it isn't
+// tried again on every run.
+const noBackend = "none"
+
 func cacheDir() string {
 	dir := os.Getenv("XDG_CACHE_HOME")
 	if dir == "" {
@@ -46,6 +53,12 @@ func cacheFile(dirpath string) string {
 	return filepath.Join(cacheDir(), fmt.Sprintf("%x.tsv", h[:8]))
 }
 
+// peakFile is where the peak pyramid of the file name in dirpath goes: a
+// directory beside the cache file, named after it.
+func peakFile(dirpath, name string) string {
+	return filepath.Join(strings.TrimSuffix(cacheFile(dirpath), ".tsv"), name+".peaks")
+}
+
 func readCache(dirpath string) map[string]Meta {
 	cache := make(map[string]Meta)
 	f, err := os.Open(cacheFile(dirpath))
@@ -65,6 +78,9 @@ func readCache(dirpath string) map[string]Meta {
 			if len(rec) >= 8 {
 				m.Spark = rec[7]
 			}
+			if len(rec) >= 9 {
+				m.Backend = rec[8]
+			}
 			cache[rec[0]] = m
 		}
 	}
@@ -81,7 +97,7 @@ func writeCache(dirpath string, metas []Meta) error {
 	w := csv.NewWriter(f)
 	w.Comma = '\t'
 	for _, m := range metas {
-		w.Write([]string{m.File, m.BPM, m.Pitch, m.Duration, m.Channels, m.Rate, m.Bits, m.Spark})
+		w.Write([]string{m.File, m.BPM, m.Pitch, m.Duration, m.Channels, m.Rate, m.Bits, m.Spark, m.Backend})
 	}
 	w.Flush()
 	return nil
@@ -137,65 +153,31 @@ func detectPitch(path string) string {
 	return fmt.Sprintf("%.0f", sum/float64(n))
 }
 
-func getSoxInfo(path string) (dur, ch, rate, bits string) {
-	out, err := exec.Command("sox", "--i", path).Output()
+// getInfo reads the format fields in the form sox --i prints them, from
+// the first audio backend that can probe path. secs is the decoded length,
+// for formats whose header doesn't give it.
+func getInfo(path string, secs float64) (dur, ch, rate, bits string) {
+	f, _, err := audio.Probe(path)
 	if err != nil {
 		return
 	}
-	for _, line := range strings.Split(string(out), "\n") {
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := strings.TrimSpace(parts[0])
-		val := strings.TrimSpace(parts[1])
-		switch key {
-		case "Duration":
-			if i := strings.Index(val, " ="); i > 0 {
-				dur = val[:i]
-			}
-		case "Channels":
-			ch = val
-		case "Sample Rate":
-			rate = val
-		case "Precision":
-			bits = strings.TrimSuffix(val, "-bit")
-		}
+	s := f.Duration()
+	if f.Frames < 0 {
+		s = secs
 	}
-	return
+	h, m := int(s)/3600, int(s)/60%60
+	dur = fmt.Sprintf("%02d:%02d:%05.2f", h, m, s-float64(h*3600+m*60))
+	return dur, strconv.Itoa(f.Channels), strconv.Itoa(f.Rate), strconv.Itoa(f.Bits)
 }
 
-func miniSparkline(path string, width int) string {
-	cmd := exec.Command("sox", path, "-c", "1", "-r", "8000", "-b", "16",
-		"-e", "signed-integer", "-t", "raw", "-")
-	raw, err := cmd.Output()
-	if err != nil || len(raw) < 2 {
+func miniSparkline(peaks []audio.Peak, width int) string {
+	if len(peaks) == 0 {
 		return strings.Repeat(string(blocks[0]), width)
 	}
-	n := len(raw) / 2
-	samples := make([]int16, n)
-	for i := range n {
-		samples[i] = int16(binary.LittleEndian.Uint16(raw[i*2 : i*2+2]))
-	}
-	peaks := make([]int16, width)
-	for i := range width {
-		s := i * n / width
-		e := (i + 1) * n / width
-		var mx int16
-		for _, v := range samples[s:e] {
-			if v < 0 {
-				v = -v
-			}
-			if v > mx {
-				mx = v
-			}
-		}
-		peaks[i] = mx
-	}
 	var maxP int16
 	for _, p := range peaks {
-		if p > maxP {
-			maxP = p
+		if p.Abs() > maxP {
+			maxP = p.Abs()
 		}
 	}
 	if maxP == 0 {
@@ -203,24 +185,60 @@ func miniSparkline(path string, width int) string {
 	}
 	var sb strings.Builder
 	for _, p := range peaks {
-		lvl := float64(p) / float64(maxP)
+		lvl := float64(p.Abs()) / float64(maxP)
 		sb.WriteRune(blocks[int(lvl*float64(len(blocks)-1))])
 	}
 	return sb.String()
 }
 
+// buildPeaks makes and saves the peak pyramid of name, and says which
+// backend decoded it; nil and noBackend if none could.
+func buildPeaks(dirpath, name string) (*audio.Pyramid, string) {
+	pyr, b, err := audio.BuildPyramid(filepath.Join(dirpath, name))
+	if err != nil {
+		// an old pyramid would have the file indexed again on every run
+		os.Remove(peakFile(dirpath, name))
+		return nil, noBackend
+	}
+	if err := pyr.Save(peakFile(dirpath, name)); err != nil {
+		fmt.Fprintf(os.Stderr, "alf-index: write peaks: %v\n", err)
+	}
+	return pyr, b.Name()
+}
+
 func indexFile(dirpath, name string) Meta {
 	path := filepath.Join(dirpath, name)
-	dur, ch, rate, bits := getSoxInfo(path)
+	// one decode gives the peak pyramid, and from it the sparkline and
+	// the length of files whose header doesn't say
+	var peaks []audio.Peak
+	var secs float64
+	pyr, backend := buildPeaks(dirpath, name)
+	if pyr != nil {
+		peaks = pyr.Columns(0, pyr.Frames, 10)
+		secs = float64(pyr.Frames) / float64(pyr.Rate)
+	}
+	dur, ch, rate, bits := getInfo(path, secs)
 	bpm := detectBPM(path)
 	pitch := detectPitch(path)
-	spark := miniSparkline(path, 10)
+	spark := miniSparkline(peaks, 10)
 	return Meta{
 		File: name, BPM: bpm, Pitch: pitch,
 		Duration: dur, Channels: ch, Rate: rate, Bits: bits, Spark: spark,
+		Backend: backend,
 	}
 }
 
+// backfill gives m, indexed before peak pyramids, its pyramid, keeping
+// the rest of what was detected.
+func backfill(dirpath string, m Meta) Meta {
+	pyr, backend := buildPeaks(dirpath, m.File)
+	if pyr != nil {
+		m.Spark = miniSparkline(pyr.Columns(0, pyr.Frames, 10), 10)
+	}
+	m.Backend = backend
+	return m
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintln(os.Stderr, "usage: alf-index <directory> [--force]")
@@ -249,24 +267,37 @@ func main() {
 
 	// check existing cache
 	existing := readCache(dirpath)
-	var toIndex []string
+	var toIndex, toBackfill []string
 	for _, f := range files {
-		if force {
+		m, ok := existing[f]
+		peaks := peakFile(dirpath, f)
+		_, err := os.Stat(peaks)
+		switch {
+		case force || !ok:
 			toIndex = append(toIndex, f)
-		} else if _, ok := existing[f]; !ok {
+		case err == nil && !audio.PyramidCurrent(peaks, filepath.Join(dirpath, f)):
+			// changed since it was indexed
 			toIndex = append(toIndex, f)
+		case err != nil && m.Backend != noBackend:
+			// indexed before pyramids
+			toBackfill = append(toBackfill, f)
 		}
 	}
 
-	if len(toIndex) == 0 {
+	if len(toIndex)+len(toBackfill) == 0 {
 		fmt.Printf("cache up to date (%d files)\n", len(files))
 		return
 	}
 
-	fmt.Printf("indexing %d/%d files...\n", len(toIndex), len(files))
+	if len(toIndex) > 0 {
+		fmt.Printf("indexing %d/%d files...\n", len(toIndex), len(files))
+	}
+	if len(toBackfill) > 0 {
+		fmt.Printf("adding peaks to %d/%d files...\n", len(toBackfill), len(files))
+	}
 
 	// index in parallel (4 workers)
-	results := make(chan Meta, len(toIndex))
+	results := make(chan Meta, len(toIndex)+len(toBackfill))
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, 4)
 
@@ -280,6 +311,16 @@ func main() {
 			results <- indexFile(dirpath, n)
 		}(name)
 	}
+	for _, name := range toBackfill {
+		wg.Add(1)
+		go func(m Meta) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fmt.Printf("  %s (peaks)\n", m.File)
+			results <- backfill(dirpath, m)
+		}(existing[name])
+	}
 
 	go func() {
 		wg.Wait()
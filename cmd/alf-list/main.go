@@ -2,17 +2,17 @@ package main
 
 import (
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/jeeruff/alf/internal/audio"
 )
 
 var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
@@ -36,6 +36,7 @@ var audioExt = map[string]bool{
 	".wav": true, ".mp3": true, ".flac": true, ".ogg": true,
 	".aif": true, ".aiff": true, ".opus": true, ".m4a": true,
 	".wma": true, ".ape": true, ".wv": true, ".alac": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true,
 }
 
 type entry struct {
@@ -59,6 +60,12 @@ func cacheFile(dirpath string) string {
 	return filepath.Join(dir, "alf", fmt.Sprintf("%x.tsv", h[:8]))
 }
 
+// peakFile is where alf-index keeps the peak pyramid of the file name in
+// dirpath.
+func peakFile(dirpath, name string) string {
+	return filepath.Join(strings.TrimSuffix(cacheFile(dirpath), ".tsv"), name+".peaks")
+}
+
 type cacheMeta struct {
 	BPM, Pitch, Dur, Ch, Rate, Bits, Spark string
 }
@@ -88,37 +95,38 @@ func readCache(dirpath string) map[string]cacheMeta {
 	return cache
 }
 
-func miniSparkline(path string, width int) string {
-	cmd := exec.Command("sox", path, "-c", "1", "-r", "8000", "-b", "16",
-		"-e", "signed-integer", "-t", "raw", "-")
-	raw, err := cmd.Output()
-	if err != nil || len(raw) < 2 {
-		return strings.Repeat(string(blocks[0]), width)
+// peaks8k streams the file at 8kHz mono from the first audio backend that
+// can decode it into width columns, never holding the whole file.
+func peaks8k(path string, width int) []audio.Peak {
+	d, _, err := audio.OpenStream(path, audio.PyramidRate, true)
+	if err != nil {
+		return nil
 	}
-	n := len(raw) / 2
-	samples := make([]int16, n)
-	for i := range n {
-		samples[i] = int16(binary.LittleEndian.Uint16(raw[i*2 : i*2+2]))
+	defer d.Close()
+	peaks, n, _, _ := audio.StreamPeaks(d, width, nil)
+	if n == 0 {
+		return nil
 	}
-	peaks := make([]int16, width)
-	for i := range width {
-		s := i * n / width
-		e := (i + 1) * n / width
-		var mx int16
-		for _, v := range samples[s:e] {
-			if v < 0 {
-				v = -v
-			}
-			if v > mx {
-				mx = v
-			}
-		}
-		peaks[i] = mx
+	return peaks
+}
+
+// miniSparkline draws path from its peak pyramid when alf-index has made
+// one, else by decoding it.
+func miniSparkline(path string, width int) string {
+	var peaks []audio.Peak
+	pyr, err := audio.ReadPyramid(peakFile(filepath.Dir(path), filepath.Base(path)), path)
+	if err == nil && pyr.Resolves(0, pyr.Frames, width) {
+		peaks = pyr.Columns(0, pyr.Frames, width)
+	} else {
+		peaks = peaks8k(path, width)
+	}
+	if peaks == nil {
+		return strings.Repeat(string(blocks[0]), width)
 	}
 	var maxP int16
 	for _, p := range peaks {
-		if p > maxP {
-			maxP = p
+		if p.Abs() > maxP {
+			maxP = p.Abs()
 		}
 	}
 	if maxP == 0 {
@@ -126,7 +134,7 @@ func miniSparkline(path string, width int) string {
 	}
 	var sb strings.Builder
 	for _, p := range peaks {
-		lvl := float64(p) / float64(maxP)
+		lvl := float64(p.Abs()) / float64(maxP)
 		sb.WriteRune(blocks[int(lvl*float64(len(blocks)-1))])
 	}
 	return sb.String()
@@ -3,18 +3,24 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/jeeruff/alf/internal/audio"
 )
 
+var blocks = []rune("▁▂▃▄▅▆▇█")
+
 var audioExt = map[string]bool{
 	".wav": true, ".mp3": true, ".flac": true, ".ogg": true,
 	".aif": true, ".aiff": true, ".opus": true, ".m4a": true,
 	".wma": true, ".ape": true, ".wv": true, ".alac": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true,
 }
 
 var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
@@ -46,6 +52,35 @@ func cacheFile(dirpath string) string {
 	return filepath.Join(dir, "alf", fmt.Sprintf("%x.tsv", h[:8]))
 }
 
+// peakFile is where alf-index keeps the peak pyramid of the file name in
+// dirpath.
+func peakFile(dirpath, name string) string {
+	return filepath.Join(strings.TrimSuffix(cacheFile(dirpath), ".tsv"), name+".peaks")
+}
+
+// pyramidSpark draws a sparkline width wide from the peak pyramid of path,
+// or returns "" if there is none that fine.
+func pyramidSpark(dirpath, path string, width int) string {
+	pyr, err := audio.ReadPyramid(peakFile(dirpath, filepath.Base(path)), path)
+	if err != nil || !pyr.Resolves(0, pyr.Frames, width) {
+		return ""
+	}
+	peaks := pyr.Columns(0, pyr.Frames, width)
+	var maxP int16
+	for _, p := range peaks {
+		maxP = max(maxP, p.Abs())
+	}
+	if maxP == 0 {
+		maxP = 1
+	}
+	var sb strings.Builder
+	for _, p := range peaks {
+		lvl := float64(p.Abs()) / float64(maxP)
+		sb.WriteRune(blocks[int(lvl*float64(len(blocks)-1))])
+	}
+	return sb.String()
+}
+
 func readCache(dirpath string) map[string]cacheMeta {
 	cache := make(map[string]cacheMeta)
 	f, err := os.Open(cacheFile(dirpath))
@@ -76,12 +111,14 @@ func escLf(s string) string {
 }
 
 func main() {
-	if len(os.Args) < 2 {
+	sparkW := flag.Int("spark", 10, "sparkline width")
+	flag.Parse()
+	if flag.NArg() < 1 {
 		return
 	}
 
 	// determine directory from first file arg
-	dirpath := filepath.Dir(os.Args[1])
+	dirpath := filepath.Dir(flag.Arg(0))
 	abs, err := filepath.Abs(dirpath)
 	if err != nil {
 		abs = dirpath
@@ -93,7 +130,7 @@ func main() {
 	}
 
 	var cmds []string
-	for _, arg := range os.Args[1:] {
+	for _, arg := range flag.Args() {
 		name := filepath.Base(arg)
 		ext := strings.ToLower(filepath.Ext(name))
 		if !audioExt[ext] {
@@ -107,7 +144,9 @@ func main() {
 
 		// build info string: spark bpm key
 		var parts []string
-		if m.Spark != "" {
+		if spark := pyramidSpark(abs, arg, *sparkW); spark != "" {
+			parts = append(parts, spark)
+		} else if m.Spark != "" {
 			parts = append(parts, m.Spark)
 		}
 		if m.BPM != "" {
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Band edges for colored waveforms: low (kick, bass) below 200Hz, high
+// (hats, cymbals) above 2kHz, mid in between. The bands are split at
+// bandRate rather than decodeRate, whose 4kHz Nyquist would leave the high
+// band only 2-4kHz and miss the hats entirely.
+const (
+	lowCut   = 200.0
+	highCut  = 2000.0
+	bandRate = specRate
+)
+
+// bandRGB is the color of a column fully dominated by each band.
+var bandRGB = [3][3]float64{
+	{230, 40, 40},  // low: red
+	{240, 180, 30}, // mid: amber
+	{50, 150, 255}, // high: blue
+}
+
+// bandGain tilts the band energies so a hat can outweigh the bass under it;
+// real material has far more energy down low.
+var bandGain = [3]float64{1, 2, 6}
+
+type rgb struct{ r, g, b uint8 }
+
+// esc is the foreground escape for c; dim darkens it for the played part.
+func (c rgb) esc(dim bool) string {
+	return "\033[38;" + c.code(dim) + "m"
+}
+
+// bgEsc is esc for the background.
+func (c rgb) bgEsc(dim bool) string {
+	return "\033[48;" + c.code(dim) + "m"
+}
+
+// code is the color part of the SGR sequence, truecolor or the nearest
+// entry of the 256-color cube depending on opts.color.
+func (c rgb) code(dim bool) string {
+	if dim {
+		c = rgb{c.r * 2 / 5, c.g * 2 / 5, c.b * 2 / 5}
+	}
+	if opts.color == "true" {
+		return fmt.Sprintf("2;%d;%d;%d", c.r, c.g, c.b)
+	}
+	q := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+	return fmt.Sprintf("5;%d", 16+36*q(c.r)+6*q(c.g)+q(c.b))
+}
+
+// biquad is an RBJ cookbook filter section.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func newBiquad(b0, b1, b2, a0, a1, a2 float64) *biquad {
+	return &biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+func lowpass(fc, rate float64) *biquad {
+	w := 2 * math.Pi * fc / rate
+	alpha := math.Sin(w) / math.Sqrt2
+	c := math.Cos(w)
+	return newBiquad((1-c)/2, 1-c, (1-c)/2, 1+alpha, -2*c, 1-alpha)
+}
+
+func highpass(fc, rate float64) *biquad {
+	w := 2 * math.Pi * fc / rate
+	alpha := math.Sin(w) / math.Sqrt2
+	c := math.Cos(w)
+	return newBiquad((1+c)/2, -(1 + c), (1+c)/2, 1+alpha, -2*c, 1-alpha)
+}
+
+// bandpass passes lo..hi with 0dB gain at the geometric center.
+func bandpass(lo, hi, rate float64) *biquad {
+	w := 2 * math.Pi * math.Sqrt(lo*hi) / rate
+	bw := math.Log2(hi / lo)
+	alpha := math.Sin(w) * math.Sinh(math.Ln2/2*bw*w/math.Sin(w))
+	c := math.Cos(w)
+	return newBiquad(alpha, 0, -alpha, 1+alpha, -2*c, 1-alpha)
+}
+
+func (f *biquad) step(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// bandLane is lane c of the nch lanes of path, decoded at bandRate and cut
+// to from..to: the samples bandColors takes.
+func bandLane(path string, c, nch int, from, to float64) []int16 {
+	samples := pcm(path, bandRate, nch == 1)
+	n := len(samples) / max(nch, 1)
+	lo, hi := int(from*float64(n)), int(to*float64(n))
+	if nch <= 1 {
+		return samples[lo:hi]
+	}
+	lane := make([]int16, hi-lo)
+	for i := range lane {
+		lane[i] = samples[(lo+i)*nch+c]
+	}
+	return lane
+}
+
+// bandColors splits samples at bandRate into low/mid/high and colors each
+// of width columns by how its energy divides between the bands.
+func bandColors(samples []int16, width int) []rgb {
+	n := len(samples)
+	if n == 0 {
+		return nil
+	}
+	filters := [3]*biquad{
+		lowpass(lowCut, bandRate),
+		bandpass(lowCut, highCut, bandRate),
+		highpass(highCut, bandRate),
+	}
+	colors := make([]rgb, width)
+	for i := range width {
+		var energy [3]float64
+		for _, v := range samples[i*n/width : (i+1)*n/width] {
+			for b, f := range filters {
+				y := f.step(float64(v))
+				energy[b] += y * y
+			}
+		}
+		// square the shares so the dominant band wins clearly
+		var w [3]float64
+		var sum float64
+		for b := range energy {
+			w[b] = energy[b] * bandGain[b]
+			w[b] *= w[b]
+			sum += w[b]
+		}
+		if sum == 0 {
+			colors[i] = rgb{128, 128, 128}
+			continue
+		}
+		var c [3]float64
+		for b := range w {
+			for k := range c {
+				c[k] += w[b] / sum * bandRGB[b][k]
+			}
+		}
+		colors[i] = rgb{uint8(c[0]), uint8(c[1]), uint8(c[2])}
+	}
+	return colors
+}
+
+// writeColorRow is writeRow with a color per cell.
+func writeColorRow(sb *strings.Builder, chars []rune, split int, colors []rgb) {
+	last := ""
+	for i, ch := range chars {
+		if esc := colors[i].esc(i < split); esc != last {
+			sb.WriteString(esc)
+			last = esc
+		}
+		sb.WriteRune(ch)
+	}
+	sb.WriteString(RST)
+}
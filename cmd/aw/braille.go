@@ -0,0 +1,67 @@
+package main
+
+// Braille cells are 2 dots wide and 4 dots tall. dotBits[row][col] is the
+// bit for that dot, counting rows from the top of the cell.
+var dotBits = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// brailleRows draws peaks (two per cell) as braille bars, top row first.
+// Every column keeps at least its bottom dot so silence still shows a baseline.
+func brailleRows(peaks []peak, mx int16, height int) [][]rune {
+	width := (len(peaks) + 1) / 2
+	dotsH := height * 4
+	rows := make([][]rune, height)
+	for r := range rows {
+		rows[r] = make([]rune, width)
+	}
+	for i, p := range peaks {
+		level := int(amp(float64(p.abs()), mx)*float64(dotsH) + 0.5)
+		if level < 1 {
+			level = 1
+		}
+		if level > dotsH {
+			level = dotsH
+		}
+		for d := range level {
+			y := dotsH - 1 - d
+			rows[y/4][i/2] |= dotBits[y%4][i%2]
+		}
+	}
+	for _, row := range rows {
+		for i := range row {
+			row[i] += 0x2800
+		}
+	}
+	return rows
+}
+
+// brailleMirrorRows draws peaks (two per cell) as dots spreading up to max
+// and down to min from the middle of the grid.
+func brailleMirrorRows(peaks []peak, mx int16, height int) [][]rune {
+	width := (len(peaks) + 1) / 2
+	dotsH := height * 4
+	zero := dotsH / 2
+	rows := make([][]rune, height)
+	for r := range rows {
+		rows[r] = make([]rune, width)
+	}
+	for i, p := range peaks {
+		hi := int(amp(float64(p.max), mx)*float64(zero) + 0.5)
+		lo := int(amp(-float64(p.min), mx)*float64(dotsH-zero) + 0.5)
+		hi = min(max(hi, 1), zero)
+		lo = min(lo, dotsH-zero)
+		for y := zero - hi; y < zero+lo; y++ {
+			rows[y/4][i/2] |= dotBits[y%4][i%2]
+		}
+	}
+	for _, row := range rows {
+		for i := range row {
+			row[i] += 0x2800
+		}
+	}
+	return rows
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+
+	"github.com/jeeruff/alf/internal/audio"
+)
+
+const (
+	diffCoarse = 30 * decodeRate // decoded samples the coarse alignment looks at
+	diffWindow = 8192            // native samples the fine alignment compares
+)
+
+// coarseLag is the offset (in decodeRate samples) at which b best matches a,
+// from the cross-correlation of their first diffCoarse samples.
+func coarseLag(a, b []int16) int {
+	a, b = a[:min(len(a), diffCoarse)], b[:min(len(b), diffCoarse)]
+	n := 1
+	for n < len(a)+len(b) {
+		n <<= 1
+	}
+	fa, fb := make([]complex128, n), make([]complex128, n)
+	for i, v := range a {
+		fa[i] = complex(float64(v), 0)
+	}
+	for i, v := range b {
+		fb[i] = complex(float64(v), 0)
+	}
+	fft(fa)
+	fft(fb)
+	// inverse transform of conj(A)·B by way of the forward one
+	for i := range fa {
+		fa[i] = cmplx.Conj(cmplx.Conj(fa[i]) * fb[i])
+	}
+	fft(fa)
+	best, lag := math.Inf(-1), 0
+	for k, c := range fa {
+		if real(c) > best {
+			best, lag = real(c), k
+		}
+	}
+	if lag > n/2 {
+		lag -= n
+	}
+	return lag
+}
+
+// alignLag is how many samples later the audio in b starts than in a: the
+// coarse lag found on the decoded files, refined at the native rate to the
+// offset where b correlates best with a just after oa, a's first
+// transient.
+func alignLag(a, b []float32, coarse, oa, rate int) int {
+	center := coarse * rate / decodeRate
+	search := rate/decodeRate + 2
+	best, bestLag := math.Inf(-1), center
+	for lag := center - search; lag <= center+search; lag++ {
+		var dot, bb float64
+		for i := oa; i < min(oa+diffWindow, len(a)); i++ {
+			if j := i + lag; j >= 0 && j < len(b) {
+				dot += float64(a[i]) * float64(b[j])
+				bb += float64(b[j]) * float64(b[j])
+			}
+		}
+		if bb == 0 {
+			continue
+		}
+		if c := dot / math.Sqrt(bb); c > best {
+			best, bestLag = c, lag
+		}
+	}
+	return bestLag
+}
+
+// diffStats is how the second version of a file differs from the first.
+type diffStats struct {
+	rate       int
+	lenA, lenB int
+	lag        int     // samples b's audio starts after a's
+	gain       float64 // least-squares gain of b over a, dB
+	peakA      float64 // dBFS
+	peakB      float64
+	first      int     // first differing sample of a, -1 if none
+	residual   float64 // RMS of b - a, dBFS
+	matched    float64 // RMS of b - a after matching gain, dBFS
+}
+
+// compare works out diffStats from samples scaled to -1..1.
+func compare(a, b []float32, rate, lag int) diffStats {
+	st := diffStats{rate: rate, lenA: len(a), lenB: len(b), lag: lag, first: -1}
+	var aa, ab, rr, pa, pb float64
+	for i, v := range a {
+		pa = math.Max(pa, math.Abs(float64(v)))
+		j := i + st.lag
+		if j < 0 || j >= len(b) {
+			continue
+		}
+		x, y := float64(v), float64(b[j])
+		aa += x * x
+		ab += x * y
+		rr += (y - x) * (y - x)
+		if st.first < 0 && v != b[j] {
+			st.first = i
+		}
+	}
+	for _, v := range b {
+		pb = math.Max(pb, math.Abs(float64(v)))
+	}
+	n := float64(max(min(len(a), len(b)), 1))
+	st.peakA, st.peakB = dbfs(pa), dbfs(pb)
+	st.residual = dbfs(math.Sqrt(rr / n))
+	st.gain, st.matched = math.Inf(-1), st.residual
+	if aa > 0 && ab > 0 {
+		g := ab / aa
+		st.gain = 20 * math.Log10(g)
+		var mr float64
+		for i, v := range a {
+			if j := i + st.lag; j >= 0 && j < len(b) {
+				d := float64(b[j]) - g*float64(v)
+				mr += d * d
+			}
+		}
+		st.matched = dbfs(math.Sqrt(mr / n))
+	}
+	return st
+}
+
+// diffSamples decodes both files, mixed down, for comparing. Files at the
+// same rate that the audio package reads itself are compared at their own
+// precision and rate, so identical ones null exactly; the rest are decoded
+// at the higher of the two rates to 16 bits, undithered.
+func diffSamples(pathA, pathB string, ra, rb int) (a, b []float32, rate int) {
+	if ra == rb && ra > 0 {
+		a, _, errA := audio.DecodeFloat(pathA, true)
+		b, _, errB := audio.DecodeFloat(pathB, true)
+		if errA == nil && errB == nil && len(a) > 0 && len(b) > 0 {
+			return a, b, ra
+		}
+	}
+	rate = max(ra, rb)
+	if rate == 0 {
+		rate = 44100
+	}
+	return toFloat(pcm(pathA, rate, true)), toFloat(pcm(pathB, rate, true)), rate
+}
+
+// toFloat scales 16-bit samples to -1..1; nil stays nil.
+func toFloat(samples []int16) []float32 {
+	if samples == nil {
+		return nil
+	}
+	out := make([]float32, len(samples))
+	for i, v := range samples {
+		out[i] = float32(v) / 32768
+	}
+	return out
+}
+
+// to16 is the inverse of toFloat, for drawing.
+func to16(samples []float32) []int16 {
+	out := make([]int16, len(samples))
+	for i, v := range samples {
+		out[i] = int16(min(max(v*32768, -32768), 32767))
+	}
+	return out
+}
+
+// renderDiff draws two versions of a file aligned on a common time axis,
+// the difference between them scaled to fit, and what changed.
+func renderDiff(pathA, pathB string, width, height int) string {
+	ia, ib := getInfo(pathA), getInfo(pathB)
+	ra, _ := strconv.Atoi(ia.sr)
+	rb, _ := strconv.Atoi(ib.sr)
+	fa, fb, rate := diffSamples(pathA, pathB, ra, rb)
+	if fa == nil || fb == nil {
+		return "  [no audio data]"
+	}
+	a, b := to16(fa), to16(fb)
+	lag := alignLag(fa, fb, coarseLag(decode(pathA), decode(pathB)), onset(a), rate)
+	st := compare(fa, fb, rate, lag)
+
+	// common axis: whichever starts later is shifted right
+	offA, offB := max(st.lag, 0), max(-st.lag, 0)
+	span := max(offA+len(a), offB+len(b))
+	pa, pb, diff := make([]int16, span), make([]int16, span), make([]int16, span)
+	copy(pa[offA:], a)
+	copy(pb[offB:], b)
+	var dmx int16
+	for i := range span {
+		d := min(max(int(pb[i])-int(pa[i]), -math.MaxInt16), math.MaxInt16)
+		diff[i] = int16(d)
+		dmx = max(dmx, int16(d), int16(-d))
+	}
+	spanDur := float64(span) / float64(rate)
+	from, to, zoomed := window(spanDur, -1)
+	lo, hi := int(from*float64(span)), int(to*float64(span))
+
+	var mx int16
+	for _, s := range [][]int16{pa[lo:hi], pb[lo:hi]} {
+		for _, p := range makePeaks(s, width) {
+			mx = max(mx, p.abs())
+		}
+	}
+	laneH := max(1, height/3)
+	var sb strings.Builder
+	if zoomed {
+		sb.WriteString(fmt.Sprintf("  %s-%s\n", fmtDur(from*spanDur), fmtDur(to*spanDur)))
+	}
+	lane := func(title string, samples []int16, ref int16) {
+		if title != "" {
+			sb.WriteString(title + "\n")
+		}
+		for _, row := range waveRows(samples, ref, width, laneH) {
+			writeRow(&sb, row, -1)
+			sb.WriteByte('\n')
+		}
+	}
+	lane(header(pathA, ia, -1), pa[lo:hi], reference(mx))
+	lane(header(pathB, ib, -1), pb[lo:hi], reference(mx))
+	lane(fmt.Sprintf("  difference  peak %s dBFS, scaled to fit", fmtLevel("%.1f", dbfs(float64(dmx)/math.MaxInt16))), diff[lo:hi], max(dmx, 1))
+	if opts.grid {
+		sb.WriteString(string(rulerRow(0, spanDur, from, to, width)) + "\n")
+	}
+	sb.WriteString(st.summary())
+	return sb.String()
+}
+
+// summary is the stats as a few lines of text.
+func (st diffStats) summary() string {
+	secs := func(n int) string { return fmtDur(float64(n) / float64(st.rate)) }
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "gain    %s dB  (peak %s -> %s dBFS)\n", fmtLevel("%+.2f", st.gain), fmtLevel("%.1f", st.peakA), fmtLevel("%.1f", st.peakB))
+	fmt.Fprintf(&sb, "length  %+d samples (%s -> %s)", st.lenB-st.lenA, secs(st.lenA), secs(st.lenB))
+	switch {
+	case st.lag > 0:
+		fmt.Fprintf(&sb, ", audio starts %d samples later", st.lag)
+	case st.lag < 0:
+		fmt.Fprintf(&sb, ", audio starts %d samples earlier", -st.lag)
+	}
+	sb.WriteByte('\n')
+	if st.first < 0 {
+		sb.WriteString("first   no differing sample\n")
+	} else {
+		fmt.Fprintf(&sb, "first   differs at sample %d (%s)\n", st.first, secs(st.first))
+	}
+	fmt.Fprintf(&sb, "null    residual %s dBFS, %s dBFS after gain match", fmtLevel("%.1f", st.residual), fmtLevel("%.1f", st.matched))
+	return sb.String()
+}
+
+// fmtLevel formats db, or -inf for silence.
+func fmtLevel(format string, db float64) string {
+	if math.IsInf(db, -1) {
+		return "-inf"
+	}
+	return fmt.Sprintf(format, db)
+}
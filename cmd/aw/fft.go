@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft is an in-place radix-2 FFT; len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Rect(1, -2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			t := complex(1, 0)
+			for k := range size / 2 {
+				a, b := x[start+k], x[start+k+size/2]*t
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				t *= w
+			}
+		}
+	}
+}
+
+// hann is a Hann window of length n.
+func hann(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	}
+	return w
+}
+
+// powerSpectrum windows the frame of samples starting at start (zero-padded
+// past either end) and returns the power of bins 0..len(win)/2.
+func powerSpectrum(samples []int16, start int, win []float64) []float64 {
+	n := len(win)
+	buf := make([]complex128, n)
+	for i := range n {
+		if j := start + i; j >= 0 && j < len(samples) {
+			buf[i] = complex(float64(samples[j])/32768*win[i], 0)
+		}
+	}
+	fft(buf)
+	pw := make([]float64, n/2+1)
+	for k := range pw {
+		a := cmplx.Abs(buf[k])
+		pw[k] = a * a
+	}
+	return pw
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// followTick is how often -follow polls the playback position.
+const followTick = 100 * time.Millisecond
+
+// posSource reports where playback is; ok turns false once it has stopped.
+// An unset position means "unchanged".
+type posSource interface {
+	position() (pos timeSpec, ok bool)
+}
+
+// openFollow resolves a -follow source: "mpd" for the player alf-play
+// drives, a unix socket or FIFO that streams one position per line, or a
+// file holding the current position. Positions are anything -start takes.
+func openFollow(src, path string) (posSource, error) {
+	if src == "mpd" {
+		return dialMPD(path)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case fi.Mode()&os.ModeSocket != 0:
+		c, err := net.Dial("unix", src)
+		if err != nil {
+			return nil, err
+		}
+		return newStreamSource(c), nil
+	case fi.Mode()&os.ModeNamedPipe != 0:
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		return newStreamSource(f), nil
+	}
+	return fileSource(src), nil
+}
+
+// follow draws the frame for each new position over the previous one until
+// the source says playback stopped.
+func follow(src posSource, dur float64, draw func(pos float64) string) {
+	fmt.Print("\033[?25l")
+	defer fmt.Print("\033[?25h\n")
+	pos, lines := -1.0, -1
+	for {
+		t, ok := src.position()
+		if !ok {
+			return
+		}
+		p := t.frac(dur, pos)
+		if t.set {
+			p = min(max(p, 0), 1)
+		}
+		if lines < 0 || p != pos {
+			pos = p
+			lines = redraw(draw(pos), lines)
+		}
+		time.Sleep(followTick)
+	}
+}
+
+// redraw prints frame over the previous one, which spanned lines newlines
+// (-1 for none yet), and returns how many newlines frame has.
+func redraw(frame string, lines int) int {
+	if lines > 0 {
+		fmt.Printf("\033[%dA", lines)
+	}
+	fmt.Print("\r\033[J" + frame)
+	return strings.Count(frame, "\n")
+}
+
+// fileSource is a file someone keeps rewriting with the position; it is
+// gone once playback stops.
+type fileSource string
+
+func (f fileSource) position() (timeSpec, bool) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return timeSpec{}, false
+	}
+	t, _ := parseTimeSpec(string(data))
+	return t, true
+}
+
+// streamSource keeps the last position line read from a socket or FIFO;
+// the stream closing means playback stopped.
+type streamSource struct {
+	mu   sync.Mutex
+	last timeSpec
+	done bool
+}
+
+func newStreamSource(r io.ReadCloser) *streamSource {
+	s := &streamSource{}
+	go func() {
+		defer r.Close()
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			if t, err := parseTimeSpec(sc.Text()); err == nil && t.set {
+				s.mu.Lock()
+				s.last = t
+				s.mu.Unlock()
+			}
+		}
+		s.mu.Lock()
+		s.done = true
+		s.mu.Unlock()
+	}()
+	return s
+}
+
+func (s *streamSource) position() (timeSpec, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, !s.done
+}
+
+// mpdSource asks mpd, which alf-play drives, for the elapsed time of the
+// song. Playback stopping or moving on to another file ends the follow.
+type mpdSource struct {
+	conn net.Conn
+	r    *bufio.Reader
+	file string
+}
+
+// mpdHost mirrors alf-play: MPD_HOST, else the socket in ~/.config/mpd.
+func mpdHost() string {
+	h := os.Getenv("MPD_HOST")
+	if h != "" {
+		return h
+	}
+	home, _ := os.UserHomeDir()
+	sock := filepath.Join(home, ".config/mpd/socket")
+	if _, err := os.Stat(sock); err == nil {
+		return sock
+	}
+	return "127.0.0.1"
+}
+
+func dialMPD(path string) (*mpdSource, error) {
+	host, password := mpdHost(), ""
+	if i := strings.LastIndex(host, "@"); i > 0 {
+		password, host = host[:i], host[i+1:]
+	}
+	var conn net.Conn
+	var err error
+	if strings.HasPrefix(host, "/") || strings.HasPrefix(host, "@") {
+		conn, err = net.Dial("unix", host)
+	} else {
+		port := os.Getenv("MPD_PORT")
+		if port == "" {
+			port = "6600"
+		}
+		conn, err = net.Dial("tcp", net.JoinHostPort(host, port))
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &mpdSource{conn: conn, r: bufio.NewReader(conn)}
+	if line, err := m.r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "OK MPD") {
+		conn.Close()
+		return nil, fmt.Errorf("mpd: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if password != "" {
+		if _, err := m.command("password " + strconv.Quote(password)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	m.file = path
+	if abs, err := filepath.Abs(path); err == nil {
+		m.file = abs
+	}
+	if real, err := filepath.EvalSymlinks(m.file); err == nil {
+		m.file = real
+	}
+	return m, nil
+}
+
+// command sends one mpd command and returns its "key: value" reply.
+func (m *mpdSource) command(cmd string) (map[string]string, error) {
+	if _, err := fmt.Fprintf(m.conn, "%s\n", cmd); err != nil {
+		return nil, err
+	}
+	reply := map[string]string{}
+	for {
+		line, err := m.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "OK":
+			return reply, nil
+		case strings.HasPrefix(line, "ACK"):
+			return nil, fmt.Errorf("mpd: %s", line)
+		}
+		if k, v, ok := strings.Cut(line, ": "); ok {
+			reply[k] = v
+		}
+	}
+}
+
+func (m *mpdSource) position() (timeSpec, bool) {
+	status, err := m.command("status")
+	if err != nil || status["state"] == "stop" || status["state"] == "" {
+		return timeSpec{}, false
+	}
+	song, err := m.command("currentsong")
+	if err != nil || strings.TrimPrefix(song["file"], "file://") != m.file {
+		return timeSpec{}, false
+	}
+	elapsed, err := strconv.ParseFloat(status["elapsed"], 64)
+	if err != nil {
+		return timeSpec{}, true
+	}
+	return timeSpec{v: elapsed, secs: true, set: true}, true
+}
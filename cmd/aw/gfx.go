@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Graphics protocols for -gfx.
+const (
+	gfxSixel = "sixel"
+	gfxKitty = "kitty"
+	gfxITerm = "iterm"
+)
+
+// detectGfx picks a graphics protocol from environment hints, which also
+// work inside lf and over SSH, then by asking the terminal when stdout is
+// one. "" means text only.
+func detectGfx() string {
+	term := os.Getenv("TERM")
+	prog := os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", term == "xterm-kitty",
+		term == "xterm-ghostty", prog == "ghostty":
+		return gfxKitty
+	case prog == "iTerm.app", prog == "WezTerm", os.Getenv("LC_TERMINAL") == "iTerm2":
+		return gfxITerm
+	case strings.Contains(term, "sixel"), strings.HasPrefix(term, "foot"),
+		strings.HasPrefix(term, "mlterm"), strings.HasPrefix(term, "yaft"):
+		return gfxSixel
+	}
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		return queryGfx()
+	}
+	return ""
+}
+
+// queryGfx sends a kitty graphics query followed by a primary device
+// attributes request and reads the answers: an OK from the kitty query, or
+// attribute 4 (sixel) in the DA1 reply.
+func queryGfx() string {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return ""
+	}
+	defer tty.Close()
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		return ""
+	}
+	defer stty(tty, saved)
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		return ""
+	}
+	if err := tty.SetReadDeadline(time.Now().Add(300 * time.Millisecond)); err != nil {
+		return ""
+	}
+	fmt.Fprint(tty, "\033_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\033\\\033[c")
+
+	var resp []byte
+	buf := make([]byte, 256)
+	for {
+		n, err := tty.Read(buf)
+		resp = append(resp, buf[:n]...)
+		if err != nil {
+			break
+		}
+		if i := bytes.Index(resp, []byte("\033[?")); i >= 0 && bytes.IndexByte(resp[i:], 'c') > 0 {
+			break
+		}
+	}
+	if bytes.Contains(resp, []byte("_Gi=31;OK")) {
+		return gfxKitty
+	}
+	if i := bytes.Index(resp, []byte("\033[?")); i >= 0 {
+		attrs := resp[i+3:]
+		if j := bytes.IndexByte(attrs, 'c'); j >= 0 {
+			for _, a := range strings.Split(string(attrs[:j]), ";") {
+				if a == "4" {
+					return gfxSixel
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// renderGfx draws the waveform of path as an image of width x height cells
+// in the given protocol, and the text header that goes above it.
+func renderGfx(path string, width, height int, pos float64, proto string, o imageOpts) (hdr, seq string) {
+	cw, ch := cellPixels()
+	o.w, o.h = width*cw, height*ch
+	v := loadView(path, pos, o.w)
+	if v == nil {
+		return "  [no audio data]", ""
+	}
+	img := renderImage(v, o, pos)
+
+	var sb strings.Builder
+	switch proto {
+	case gfxSixel:
+		encodeSixel(&sb, img)
+	case gfxKitty, gfxITerm:
+		var data bytes.Buffer
+		png.Encode(&data, img)
+		if proto == gfxKitty {
+			encodeKitty(&sb, data.Bytes(), width, height)
+		} else {
+			encodeITerm(&sb, data.Bytes(), width, height)
+		}
+	}
+	return header(path, v.info, pos), sb.String()
+}
+
+// placeGfx writes a kitty or iTerm2 image straight to the terminal at cell
+// x, y (0-based), for previewers like lf that only pass sixel through.
+func placeGfx(img string, x, y int) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	_, err = fmt.Fprintf(tty, "\0337\033[%d;%dH%s\0338", y+1, x+1, img)
+	return err
+}
+
+// clearGfx deletes every kitty image placement, for the lf cleaner.
+func clearGfx() {
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		fmt.Fprint(tty, "\033_Ga=d,d=A,q=2\033\\")
+		tty.Close()
+	}
+}
+
+// encodeSixel writes img as a sixel image. Pixels with zero alpha are left
+// transparent. Waveforms use few colors; past 256 they snap to a 6x6x6 cube.
+func encodeSixel(w io.Writer, img *image.RGBA) {
+	b := img.Bounds()
+	W, H := b.Dx(), b.Dy()
+	idx := make([]int, W*H)
+	var palette []color.RGBA
+	seen := map[color.RGBA]int{}
+	cube := false
+	for y := range H {
+		for x := range W {
+			c := img.RGBAAt(b.Min.X+x, b.Min.Y+y)
+			if c.A == 0 {
+				idx[y*W+x] = -1
+				continue
+			}
+			c.A = 255
+			i, ok := seen[c]
+			if !ok {
+				i = len(palette)
+				seen[c] = i
+				palette = append(palette, c)
+			}
+			idx[y*W+x] = i
+		}
+	}
+	if len(palette) > 256 {
+		cube = true
+		q := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+		remap := make([]int, len(palette))
+		for i, c := range palette {
+			remap[i] = 36*q(c.R) + 6*q(c.G) + q(c.B)
+		}
+		for i, k := range idx {
+			if k >= 0 {
+				idx[i] = remap[k]
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	fmt.Fprintf(bw, "\033P0;1;0q\"1;1;%d;%d", W, H)
+	if cube {
+		for i := range 216 {
+			fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+		}
+	} else {
+		for i, c := range palette {
+			fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, int(c.R)*100/255, int(c.G)*100/255, int(c.B)*100/255)
+		}
+	}
+	bits := make([]byte, W)
+	for top := 0; top < H; top += 6 {
+		used := map[int]bool{}
+		var order []int
+		for y := top; y < min(top+6, H); y++ {
+			for x := range W {
+				if k := idx[y*W+x]; k >= 0 && !used[k] {
+					used[k] = true
+					order = append(order, k)
+				}
+			}
+		}
+		for n, k := range order {
+			for x := range W {
+				bits[x] = 0
+				for r := 0; r < 6 && top+r < H; r++ {
+					if idx[(top+r)*W+x] == k {
+						bits[x] |= 1 << r
+					}
+				}
+			}
+			fmt.Fprintf(bw, "#%d", k)
+			writeSixelRuns(bw, bits)
+			if n < len(order)-1 {
+				bw.WriteByte('$')
+			}
+		}
+		bw.WriteByte('-')
+	}
+	bw.WriteString("\033\\")
+}
+
+// writeSixelRuns writes one band row, run-length encoded.
+func writeSixelRuns(w *bufio.Writer, bits []byte) {
+	for x := 0; x < len(bits); {
+		run := 1
+		for x+run < len(bits) && bits[x+run] == bits[x] {
+			run++
+		}
+		ch := byte('?' + bits[x])
+		if run > 3 {
+			fmt.Fprintf(w, "!%d%c", run, ch)
+		} else {
+			for range run {
+				w.WriteByte(ch)
+			}
+		}
+		x += run
+	}
+}
+
+// encodeKitty sends a PNG with the kitty graphics protocol, scaled to
+// cols x rows cells, in 4096-byte base64 chunks.
+func encodeKitty(w io.Writer, data []byte, cols, rows int) {
+	b64 := base64.StdEncoding.EncodeToString(data)
+	for i := 0; i < len(b64); i += 4096 {
+		chunk := b64[i:min(i+4096, len(b64))]
+		more := 0
+		if i+4096 < len(b64) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(w, "\033_Ga=T,f=100,q=2,c=%d,r=%d,m=%d;%s\033\\", cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(w, "\033_Gm=%d;%s\033\\", more, chunk)
+		}
+	}
+}
+
+// encodeITerm sends a PNG as an iTerm2 inline image of cols x rows cells.
+func encodeITerm(w io.Writer, data []byte, cols, rows int) {
+	fmt.Fprintf(w, "\033]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=0:%s\a",
+		len(data), cols, rows, base64.StdEncoding.EncodeToString(data))
+}
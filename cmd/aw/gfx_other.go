@@ -0,0 +1,6 @@
+//go:build !linux && !darwin
+
+package main
+
+// cellPixels is the size of one terminal cell in pixels.
+func cellPixels() (w, h int) { return 8, 16 }
@@ -0,0 +1,26 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// cellPixels is the size of one terminal cell in pixels, from the window
+// size of the controlling terminal; 8x16 when it does not say.
+func cellPixels() (w, h int) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return 8, 16
+	}
+	defer tty.Close()
+	var ws struct{ row, col, xpixel, ypixel uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(),
+		uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.col == 0 || ws.row == 0 || ws.xpixel == 0 || ws.ypixel == 0 {
+		return 8, 16
+	}
+	return int(ws.xpixel / ws.col), int(ws.ypixel / ws.row)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// rulerSteps are the seconds ruler spacings, smallest first.
+var rulerSteps = []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+// rulerRow is a line of width cells lined up with the peak columns for the
+// window from..to of a file of dur seconds. With a BPM it shows bar numbers
+// and beat ticks (4/4, first downbeat at zero); otherwise a seconds scale.
+func rulerRow(bpm, dur, from, to float64, width int) []rune {
+	row := make([]rune, width)
+	for i := range row {
+		row[i] = ' '
+	}
+	t0, t1 := from*dur, to*dur
+	if t1 <= t0 || width < 1 {
+		return row
+	}
+	cols := float64(width) / (t1 - t0) // columns per second
+	col := func(t float64) int { return int((t - t0) * cols) }
+	tick := func(t float64, r rune) {
+		if x := col(t); x >= 0 && x < width {
+			row[x] = r
+		}
+	}
+	// labels overwrite ticks but not each other
+	label := func(t float64, s string) {
+		x := col(t)
+		if x < 0 || x+len(s) > width {
+			return
+		}
+		for i := x; i < x+len(s); i++ {
+			if row[i] != ' ' && row[i] != '╵' && row[i] != '╹' {
+				return
+			}
+		}
+		copy(row[x:], []rune(s))
+	}
+
+	if bpm > 0 {
+		beat := 60 / bpm
+		perBeat := beat * cols
+		labelW := len(strconv.Itoa(int(t1/beat)/4 + 1))
+		every := 1 // label every Nth bar
+		for float64(every)*4*perBeat < float64(labelW+1) {
+			every *= 2
+		}
+		first := int(math.Ceil(t0 / beat))
+		for k := first; float64(k)*beat < t1; k++ {
+			switch {
+			case k%4 == 0:
+				tick(float64(k)*beat, '╹')
+			case perBeat >= 2:
+				tick(float64(k)*beat, '╵')
+			}
+		}
+		for k := first; float64(k)*beat < t1; k++ {
+			if k%4 == 0 && (k/4)%every == 0 {
+				label(float64(k)*beat, strconv.Itoa(k/4+1))
+			}
+		}
+		return row
+	}
+
+	// the finest step whose widest label still leaves a gap
+	step := rulerSteps[len(rulerSteps)-1]
+	for _, s := range rulerSteps {
+		if s*cols < 3 {
+			continue
+		}
+		labelW := 0
+		for k := math.Ceil(t0 / s); k*s < t1; k++ {
+			labelW = max(labelW, len(fmtTick(k*s, s)))
+		}
+		if s*cols >= float64(labelW+1) {
+			step = s
+			break
+		}
+	}
+	first := math.Ceil(t0 / step * 2)
+	for k := first; k*step/2 < t1; k++ {
+		tick(k*step/2, '╵')
+	}
+	for k := math.Ceil(t0 / step); k*step < t1; k++ {
+		label(k*step, fmtTick(k*step, step))
+	}
+	return row
+}
+
+// fmtTick labels a ruler position, with decimals only when the step has them.
+func fmtTick(t, step float64) string {
+	if t >= 60 {
+		return fmt.Sprintf("%d:%02d", int(t)/60, int(t)%60)
+	}
+	if step < 1 {
+		return strconv.FormatFloat(math.Round(t*100)/100, 'f', -1, 64) + "s"
+	}
+	return fmt.Sprintf("%ds", int(math.Round(t)))
+}
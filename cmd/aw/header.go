@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultHeader is the header aw has always drawn, as a -header template.
+const defaultHeader = "  {name}  {bits}b {rate}Hz {ch}ch  [{?time}{time} / {/}{dur}]{?bpm}  {bpm}bpm{/}{?note}  {note}{/}{?markers}  {markers}{/}"
+
+// headerKeys are the -header placeholders besides {cache.COLUMN}.
+var headerKeys = []string{
+	"name", "path", "dir", "ext", "dur", "time", "pos", "rate", "bits", "ch",
+	"bpm", "note", "hz", "size", "bytes", "markers", "cues", "loops", "backend",
+}
+
+// cacheCols are the columns of alf-index's cache, after the file name.
+var cacheCols = []string{"bpm", "pitch", "duration", "channels", "rate", "bits", "spark", "backend"}
+
+// tmplNode is a piece of a -header template: literal text, a placeholder
+// with an optional printf width/precision, or a block shown only when key
+// is non-empty ('?') or empty ('!').
+type tmplNode struct {
+	text string
+	key  string
+	spec string
+	cond byte
+	body []tmplNode
+}
+
+var specRe = regexp.MustCompile(`^-?[0-9]*(\.[0-9]+)?$`)
+
+// parseHeader parses a -header template:
+//
+//	{key}          value of key
+//	{key:-12}      left-aligned in 12 columns ({key:12} right-aligns)
+//	{key:.8}       cut to 8 characters; combine as {key:-12.12}
+//	{?key}...{/}   only when key has a value
+//	{!key}...{/}   only when it has none
+//	{{ and }}      literal braces
+func parseHeader(s string) ([]tmplNode, error) {
+	nodes, _, closed, err := parseNodes(s)
+	if err != nil {
+		return nil, err
+	}
+	if closed {
+		return nil, fmt.Errorf("header: {/} without an opening {?key}")
+	}
+	return nodes, nil
+}
+
+// parseNodes parses up to the end of s or the first {/}; rest is what
+// follows the {/} and closed whether there was one.
+func parseNodes(s string) (nodes []tmplNode, rest string, closed bool, err error) {
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, tmplNode{text: text.String()})
+			text.Reset()
+		}
+	}
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, "{{"), strings.HasPrefix(s, "}}"):
+			text.WriteByte(s[0])
+			s = s[2:]
+			continue
+		case s[0] != '{':
+			text.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return nil, "", false, fmt.Errorf("header: unclosed %q", s)
+		}
+		tag := s[1:end]
+		s = s[end+1:]
+		flush()
+		switch {
+		case tag == "/":
+			return nodes, s, true, nil
+		case strings.HasPrefix(tag, "?"), strings.HasPrefix(tag, "!"):
+			if err := checkKey(tag[1:]); err != nil {
+				return nil, "", false, err
+			}
+			body, after, closed, err := parseNodes(s)
+			if err != nil {
+				return nil, "", false, err
+			}
+			if !closed {
+				return nil, "", false, fmt.Errorf("header: {%s} without {/}", tag)
+			}
+			nodes = append(nodes, tmplNode{key: tag[1:], cond: tag[0], body: body})
+			s = after
+		default:
+			key, spec, _ := strings.Cut(tag, ":")
+			if err := checkKey(key); err != nil {
+				return nil, "", false, err
+			}
+			if !specRe.MatchString(spec) {
+				return nil, "", false, fmt.Errorf("header: bad width %q in {%s}", spec, tag)
+			}
+			nodes = append(nodes, tmplNode{key: key, spec: spec})
+		}
+	}
+	flush()
+	return nodes, "", false, nil
+}
+
+func checkKey(key string) error {
+	if col, ok := strings.CutPrefix(key, "cache."); ok {
+		for _, c := range cacheCols {
+			if c == col {
+				return nil
+			}
+		}
+		return fmt.Errorf("header: unknown cache column %q (have %s)", col, strings.Join(cacheCols, ", "))
+	}
+	for _, k := range headerKeys {
+		if k == key {
+			return nil
+		}
+	}
+	return fmt.Errorf("header: unknown placeholder {%s} (have %s, cache.COLUMN)", key, strings.Join(headerKeys, ", "))
+}
+
+// usesKey says whether any placeholder or condition in nodes is key.
+func usesKey(nodes []tmplNode, key string) bool {
+	for _, n := range nodes {
+		if n.key == key || usesKey(n.body, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func execHeader(nodes []tmplNode, vals map[string]string) string {
+	var sb strings.Builder
+	for _, n := range nodes {
+		switch {
+		case n.cond == '?' && vals[n.key] != "", n.cond == '!' && vals[n.key] == "":
+			sb.WriteString(execHeader(n.body, vals))
+		case n.cond != 0:
+		case n.key != "":
+			sb.WriteString(fmtSpec(vals[n.key], n.spec))
+		default:
+			sb.WriteString(n.text)
+		}
+	}
+	return sb.String()
+}
+
+// fmtSpec lays out v for a {key:spec} placeholder the way %spec s would,
+// but counting terminal cells rather than bytes, so accented and CJK
+// names line up and are never cut inside a character.
+func fmtSpec(v, spec string) string {
+	wstr, pstr, cut := strings.Cut(strings.TrimPrefix(spec, "-"), ".")
+	if cut {
+		prec, _ := strconv.Atoi(pstr)
+		v = truncCells(v, prec)
+	}
+	width, _ := strconv.Atoi(wstr)
+	pad := width - cells(v)
+	switch {
+	case pad <= 0:
+		return v
+	case strings.HasPrefix(spec, "-"):
+		return v + strings.Repeat(" ", pad)
+	}
+	return strings.Repeat(" ", pad) + v
+}
+
+// wideRunes are the ranges a terminal draws two cells wide: East Asian
+// wide and fullwidth characters and the common emoji blocks.
+var wideRunes = [][2]rune{
+	{0x1100, 0x115f}, {0x2e80, 0x303e}, {0x3041, 0x33ff}, {0x3400, 0x4dbf},
+	{0x4e00, 0x9fff}, {0xa000, 0xa4cf}, {0xac00, 0xd7a3}, {0xf900, 0xfaff},
+	{0xfe30, 0xfe4f}, {0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x1f300, 0x1f64f},
+	{0x1f900, 0x1f9ff}, {0x20000, 0x3fffd},
+}
+
+// runeCells is how many terminal cells r takes: none for combining marks.
+func runeCells(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	for _, w := range wideRunes {
+		if r >= w[0] && r <= w[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// cells is how many terminal cells s takes.
+func cells(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeCells(r)
+	}
+	return n
+}
+
+// truncCells cuts s to at most n cells, keeping combining marks with the
+// character before them.
+func truncCells(s string, n int) string {
+	used := 0
+	for i, r := range s {
+		if used += runeCells(r); used > n {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// headerVals are the placeholder values for path. time and pos are empty
+// without a playback position.
+func headerVals(path string, info audioInfo, pos float64) map[string]string {
+	cmeta := readCacheMeta(path)
+	m := readMarkers(path)
+	vals := map[string]string{
+		"name":    displayName(path),
+		"dur":     fmtDur(info.dur),
+		"rate":    info.sr,
+		"bits":    info.bits,
+		"ch":      info.ch,
+		"bpm":     cmeta.BPM,
+		"note":    hzToNote(cmeta.Pitch),
+		"hz":      cmeta.Pitch,
+		"markers": strings.TrimSpace(m.summary()),
+	}
+	if opts.headerBackend {
+		vals["backend"] = backend(path)
+	}
+	if len(m.cues) > 0 {
+		vals["cues"] = strconv.Itoa(len(m.cues))
+	}
+	if len(m.loops) > 0 {
+		vals["loops"] = strconv.Itoa(len(m.loops))
+	}
+	if pos >= 0 {
+		vals["time"] = fmtDur(info.dur * pos)
+		vals["pos"] = fmt.Sprintf("%.0f%%", pos*100)
+	}
+	size := int64(len(stdin.data))
+	if path != "-" {
+		if abs, err := filepath.Abs(path); err == nil {
+			vals["path"] = abs
+			vals["dir"] = filepath.Dir(abs)
+		}
+		vals["ext"] = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		size = 0
+		if fi, err := os.Stat(path); err == nil {
+			size = fi.Size()
+		}
+	}
+	if size > 0 {
+		vals["size"] = fmtSize(size)
+		vals["bytes"] = strconv.FormatInt(size, 10)
+	}
+	for col, v := range cmeta.fields {
+		vals["cache."+col] = v
+	}
+	return vals
+}
+
+// header is the line above the waveform, from the -header template; empty
+// when the template is.
+func header(path string, info audioInfo, pos float64) string {
+	return execHeader(opts.header, headerVals(path, info, pos))
+}
+
+func fmtSize(b int64) string {
+	switch {
+	case b >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(b)/float64(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(b)/float64(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.0fK", float64(b)/float64(1<<10))
+	default:
+		return fmt.Sprintf("%dB", b)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// imageOpts are the -png/-svg settings.
+type imageOpts struct {
+	w, h   int
+	fg, bg color.RGBA
+	played color.RGBA // fg for the part left of the playhead
+	head   color.RGBA // the playhead line
+}
+
+// parseColor reads #rgb, #rrggbb or "none" (transparent).
+func parseColor(s string) (color.RGBA, error) {
+	if s == "none" {
+		return color.RGBA{}, nil
+	}
+	h := strings.TrimPrefix(s, "#")
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil || len(h) != 6 {
+		return color.RGBA{}, fmt.Errorf("bad color %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// parseSize reads WxH.
+func parseSize(s string) (w, h int, err error) {
+	if _, err := fmt.Sscanf(s, "%dx%d", &w, &h); err != nil || w < 1 || h < 1 {
+		return 0, 0, fmt.Errorf("bad size %q (want WxH)", s)
+	}
+	return w, h, nil
+}
+
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	m := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t) }
+	return color.RGBA{m(a.R, b.R), m(a.G, b.G), m(a.B, b.B), m(a.A, b.A)}
+}
+
+// canvas is what the waveform is painted on: a PNG image or an SVG document.
+type canvas interface {
+	fill(x, y, w, h int, c color.RGBA)
+}
+
+type pngCanvas struct{ img *image.RGBA }
+
+func (p pngCanvas) fill(x, y, w, h int, c color.RGBA) {
+	for yy := y; yy < y+h; yy++ {
+		for xx := x; xx < x+w; xx++ {
+			p.img.SetRGBA(xx, yy, c)
+		}
+	}
+}
+
+type svgCanvas struct{ w *bufio.Writer }
+
+func (s svgCanvas) fill(x, y, w, h int, c color.RGBA) {
+	if c.A == 0 || w < 1 || h < 1 {
+		return
+	}
+	fmt.Fprintf(s.w, `<rect x="%d" y="%d" width="%d" height="%d" fill="#%02x%02x%02x"/>`+"\n",
+		x, y, w, h, c.R, c.G, c.B)
+}
+
+// paint draws the view onto c the way renderFull draws it into cells: bars
+// from the bottom, or mirrored min/max with an RMS body under -m, one lane
+// per channel under -l.
+func paint(c canvas, v *view, o imageOpts, pos float64) {
+	c.fill(0, 0, o.w, o.h, o.bg)
+	split := v.split(pos, o.w)
+	mx := v.ref(o.w)
+	laneH := o.h / v.lanes()
+	for lane := range v.lanes() {
+		top := lane * laneH
+		var bands []rgb
+		if opts.color != "" && !v.reduced {
+			bands = v.bands(lane, o.w)
+		}
+		for x, p := range v.peaks(lane, o.w) {
+			fg := o.fg
+			if bands != nil {
+				fg = color.RGBA{bands[x].r, bands[x].g, bands[x].b, 255}
+			}
+			if x < split {
+				fg = mix(fg, o.played, 0.7)
+			}
+			if opts.mirror {
+				half := float64(laneH) / 2
+				mid := top + laneH/2
+				hi := int(amp(float64(p.max), mx) * half)
+				lo := int(amp(-float64(p.min), mx) * half)
+				body := int(amp(p.rms, mx) * half)
+				c.fill(x, mid-hi, 1, hi+lo+1, mix(fg, o.bg, 0.45))
+				c.fill(x, mid-body, 1, 2*body+1, fg)
+			} else {
+				h := max(int(amp(float64(p.abs()), mx)*float64(laneH)), 1)
+				c.fill(x, top+laneH-h, 1, h, fg)
+			}
+		}
+	}
+	if split >= 0 && split < o.w {
+		c.fill(split, 0, 1, o.h, o.head)
+	}
+}
+
+// imageOptions parses the -size and color flags.
+func imageOptions(size, fg, bg, played, head string) (imageOpts, error) {
+	var o imageOpts
+	var err error
+	if o.w, o.h, err = parseSize(size); err != nil {
+		return o, err
+	}
+	for _, c := range []struct {
+		dst *color.RGBA
+		s   string
+	}{{&o.fg, fg}, {&o.bg, bg}, {&o.played, played}, {&o.head, head}} {
+		if *c.dst, err = parseColor(c.s); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// writeImage renders path as a PNG or SVG (by format) to out, "-" for stdout.
+func writeImage(path, out, format string, o imageOpts, pos float64) error {
+	v := loadView(path, pos, o.w)
+	if v == nil {
+		return fmt.Errorf("%s: no audio data", path)
+	}
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "svg" {
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+			o.w, o.h, o.w, o.h)
+		paint(svgCanvas{bw}, v, o, pos)
+		fmt.Fprintln(bw, "</svg>")
+		return bw.Flush()
+	}
+	return png.Encode(w, renderImage(v, o, pos))
+}
+
+func renderImage(v *view, o imageOpts, pos float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, o.w, o.h))
+	paint(pngCanvas{img}, v, o, pos)
+	return img
+}
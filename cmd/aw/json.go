@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+)
+
+// jsonPeaks are per-column levels as fractions of full scale.
+type jsonPeaks struct {
+	Peaks []float64 `json:"peaks"`
+	Min   []float64 `json:"min"`
+	Max   []float64 `json:"max"`
+	RMS   []float64 `json:"rms"`
+}
+
+type jsonCue struct {
+	ID    uint32  `json:"id"`
+	Time  float64 `json:"time"`
+	Frame int64   `json:"frame"`
+	Label string  `json:"label,omitempty"`
+}
+
+type jsonLoop struct {
+	ID    uint32  `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Type  string  `json:"type"`
+	Count uint32  `json:"count"`
+}
+
+type jsonFile struct {
+	Path     string     `json:"path"`
+	Name     string     `json:"name"`
+	Rate     int        `json:"rate"`
+	Bits     int        `json:"bits"`
+	Channels int        `json:"channels"`
+	Duration float64    `json:"duration"`
+	Backend  string     `json:"backend,omitempty"` // audio backend that decoded it
+	BPM      float64    `json:"bpm,omitempty"`
+	Pitch    float64    `json:"pitch,omitempty"`
+	Note     string     `json:"note,omitempty"`
+	Start    float64    `json:"start"` // window shown, in seconds
+	End      float64    `json:"end"`
+	Cues     []jsonCue  `json:"cues,omitempty"`
+	Loops    []jsonLoop `json:"loops,omitempty"`
+	jsonPeaks
+	Lanes []jsonPeaks `json:"lanes,omitempty"` // per channel, with -l
+	Error string      `json:"error,omitempty"`
+}
+
+type jsonDir struct {
+	Dir     string     `json:"dir"`
+	Current string     `json:"current,omitempty"`
+	Files   []jsonFile `json:"files"`
+}
+
+func peaksJSON(peaks []peak) jsonPeaks {
+	var j jsonPeaks
+	for _, p := range peaks {
+		j.Peaks = append(j.Peaks, round4(float64(p.abs())/32768))
+		j.Min = append(j.Min, round4(float64(p.min)/32768))
+		j.Max = append(j.Max, round4(float64(p.max)/32768))
+		j.RMS = append(j.RMS, round4(p.rms/32768))
+	}
+	return j
+}
+
+func round4(v float64) float64 { return math.Round(v*1e4) / 1e4 }
+
+// fileJSON is what aw knows about path: format, cached tags, markers and
+// width peak columns (of the mono mixdown, and of each channel with -l).
+func fileJSON(path string, width int, pos float64) jsonFile {
+	abs, err := filepath.Abs(path)
+	if err != nil || path == "-" {
+		abs = path
+	}
+	j := jsonFile{Path: abs, Name: displayName(path)}
+	cmeta := readCacheMeta(path)
+	j.BPM, _ = strconv.ParseFloat(cmeta.BPM, 64)
+	j.Pitch, _ = strconv.ParseFloat(cmeta.Pitch, 64)
+	j.Note = hzToNote(cmeta.Pitch)
+
+	v := loadView(path, pos, width)
+	if v == nil {
+		j.Error = "no audio data"
+		return j
+	}
+	j.Rate, _ = strconv.Atoi(v.info.sr)
+	j.Bits, _ = strconv.Atoi(v.info.bits)
+	j.Channels, _ = strconv.Atoi(v.info.ch)
+	j.Duration = round4(v.dur)
+	j.Backend = backends[path]
+	j.Start, j.End = round4(v.from*v.dur), round4(v.to*v.dur)
+
+	m := readMarkers(path)
+	for _, c := range m.cues {
+		j.Cues = append(j.Cues, jsonCue{c.id, round4(m.secs(c.pos)), c.pos, c.label})
+	}
+	for _, l := range m.loops {
+		j.Loops = append(j.Loops, jsonLoop{l.id, round4(m.secs(l.start)), round4(m.secs(l.end)), l.kindName(), l.count})
+	}
+
+	if v.lanes() == 1 {
+		j.jsonPeaks = peaksJSON(v.peaks(0, width))
+		return j
+	}
+	var mono []int16
+	if !v.reduced {
+		mono = decode(path)
+	} else if e := envelope(path, 1, width); e != nil {
+		mono = e[0]
+	}
+	n := len(mono)
+	j.jsonPeaks = peaksJSON(makePeaks(mono[int(v.from*float64(n)):int(v.to*float64(n))], width))
+	for c := range v.lanes() {
+		j.Lanes = append(j.Lanes, peaksJSON(v.peaks(c, width)))
+	}
+	return j
+}
+
+// dirJSON is fileJSON for every audio file in dirpath; current names the
+// selected file in combo mode.
+func dirJSON(dirpath, current string, width int) jsonDir {
+	abs, err := filepath.Abs(dirpath)
+	if err != nil {
+		abs = dirpath
+	}
+	j := jsonDir{Dir: abs, Current: current, Files: []jsonFile{}}
+	files, _ := audioFiles(dirpath)
+	for _, f := range files {
+		j.Files = append(j.Files, fileJSON(filepath.Join(dirpath, f), width, -1))
+	}
+	return j
+}
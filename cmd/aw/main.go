@@ -1,9 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"math"
@@ -13,6 +14,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/jeeruff/alf/internal/audio"
 )
 
 var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
@@ -36,50 +40,163 @@ var audioExt = map[string]bool{
 	".wav": true, ".mp3": true, ".flac": true, ".ogg": true,
 	".aif": true, ".aiff": true, ".opus": true, ".m4a": true,
 	".wma": true, ".ape": true, ".wv": true, ".alac": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true,
+}
+
+// renderOpts holds the drawing modes chosen on the command line.
+type renderOpts struct {
+	braille  bool
+	lanes    bool   // one lane per channel instead of a mono mixdown
+	mirror   bool   // min/max around a zero line with an RMS body
+	color    string // frequency band colors: "", "256" or "true"
+	spectro  bool   // spectrogram instead of waveform
+	spectrum bool   // average spectrum instead of waveform; a column in -d
+	logFreq  bool   // log frequency axis for the spectrogram
+
+	start, end timeSpec // zoom window, unset for the whole file
+
+	dbRange    float64 // dB shown below the reference; 0 for a linear scale
+	norm       string  // amplitude reference: "file", "shared" or "fs"
+	sharedPeak int16   // loudest peak of the listing for -norm shared
+
+	grid bool // bar/beat grid or seconds ruler under the waveform
+
+	maxTime time.Duration // decoding time allowed per file, 0 for no limit
+	maxMem  int64         // bytes of decoded samples allowed per view, 0 for no limit
+
+	header        []tmplNode // parsed -header template; none for no header line
+	headerBackend bool       // the template shows {backend}, which may need a decode
 }
 
+var opts renderOpts
+
 const (
 	DIM    = "\033[38;5;240m"
 	BRIGHT = "\033[0m"
 	RST    = "\033[0m"
 )
 
+// decodeRate is the sample rate all analysis runs at.
+const decodeRate = 8000
+
+// decoded memoizes pcm and infos getInfo: the combo view draws the
+// current file twice, -norm shared reads every listed file before drawing
+// any of them and -follow redraws the same file on every tick. backends
+// records which audio backend decoded each path.
+var (
+	decoded  = map[string][]int16{}
+	infos    = map[string]audioInfo{}
+	backends = map[string]string{}
+)
+
 func decode(path string) []int16 {
-	cmd := exec.Command("sox", path, "-c", "1", "-r", "8000", "-b", "16",
-		"-e", "signed-integer", "-t", "raw", "-")
-	raw, err := cmd.Output()
-	if err != nil || len(raw) < 2 {
+	return pcm(path, decodeRate, true)
+}
+
+// decodeChannels decodes without mixing down and splits the interleaved
+// samples into one slice per channel.
+func decodeChannels(path string, nch int) [][]int16 {
+	samples := pcm(path, decodeRate, false)
+	if samples == nil || nch < 1 {
 		return nil
 	}
-	n := len(raw) / 2
-	samples := make([]int16, n)
-	for i := range n {
-		samples[i] = int16(binary.LittleEndian.Uint16(raw[i*2 : i*2+2]))
+	n := len(samples) / nch
+	chans := make([][]int16, nch)
+	for c := range chans {
+		chans[c] = make([]int16, n)
+		for i := range n {
+			chans[c][i] = samples[i*nch+c]
+		}
+	}
+	return chans
+}
+
+// pcm gets signed 16-bit samples at rate, mixed down to one channel when
+// mono is set, from the first audio backend that can decode path.
+func pcm(path string, rate int, mono bool) []int16 {
+	key := pcmKey(path, rate, mono)
+	if samples, ok := decoded[key]; ok {
+		return samples
+	}
+	var samples []int16
+	if path == "-" {
+		samples = decodeStdin(rate, mono)
+	} else if d, b, err := audio.OpenStream(path, rate, mono); err == nil {
+		samples, backends[path] = decodeStream(path, d), b.Name()
+		d.Close()
 	}
+	decoded[key] = samples
 	return samples
 }
 
-func makePeaks(samples []int16, width int) []int16 {
-	n := len(samples)
-	if n == 0 {
-		return nil
+func pcmKey(path string, rate int, mono bool) string {
+	return fmt.Sprintf("%s\x00%d\x00%t", path, rate, mono)
+}
+
+// backend is the name of the backend that decoded path, decoding it if
+// nothing has read it yet.
+func backend(path string) string {
+	if b, ok := backends[path]; ok {
+		return b
 	}
-	p := make([]int16, width)
-	for i := range width {
-		s := i * n / width
-		e := (i + 1) * n / width
-		var mx int16
-		for _, v := range samples[s:e] {
-			if v < 0 {
-				v = -v
-			}
-			if v > mx {
-				mx = v
+	decode(path)
+	return backends[path]
+}
+
+// decodeStdin decodes the stream on stdin in-process when it has a header
+// the audio package reads, else through sox with the stream's options.
+// Raw PCM has no header to go by, so it always goes to sox.
+func decodeStdin(rate int, mono bool) []int16 {
+	if !stdin.raw {
+		if d, err := audio.NewDecoder(bytes.NewReader(stdin.data)); err == nil {
+			samples, _, err := audio.Convert(d, rate, mono)
+			if err == nil && len(samples) > 0 {
+				backends["-"] = audio.Native.Name()
+				return samples
 			}
 		}
-		p[i] = mx
 	}
-	return p
+	backends["-"] = audio.Sox.Name()
+	return runSox("-", rate, mono)
+}
+
+// runSox decodes path through sox, taking input options from soxInput.
+func runSox(path string, rate int, mono bool) []int16 {
+	argv, in := soxInput(path)
+	if mono {
+		argv = append(argv, "-c", "1")
+	}
+	argv = append(argv, "-r", strconv.Itoa(rate), "-b", "16", "-e", "signed-integer", "-t", "raw", "-")
+	cmd := exec.Command("sox", append([]string{"-D"}, argv...)...)
+	cmd.Stdin = in
+	raw, err := cmd.Output()
+	if err != nil || len(raw) < 2 {
+		return nil
+	}
+	return audio.Samples16(raw)
+}
+
+// peak summarizes the samples of one column.
+type peak struct {
+	min, max int16
+	rms      float64
+}
+
+// abs is the larger excursion from zero in either direction.
+func (p peak) abs() int16 {
+	if p.min == math.MinInt16 {
+		return math.MaxInt16
+	}
+	return max(p.max, -p.min)
+}
+
+func makePeaks(samples []int16, width int) []peak {
+	if len(samples) == 0 {
+		return nil
+	}
+	p := audio.NewPeaks(width, int64(len(samples)))
+	p.Add(samples)
+	return toPeaks(p.Finish())
 }
 
 type audioInfo struct {
@@ -87,12 +204,50 @@ type audioInfo struct {
 	dur          float64
 }
 
+// getInfo is probeInfo with the duration of a file whose header doesn't
+// give its length taken from its peak pyramid, or from decoding it.
 func getInfo(path string) audioInfo {
-	cmd := exec.Command("sox", "--i", path)
-	out, err := cmd.Output()
-	if err != nil {
-		return audioInfo{}
+	info := probeInfo(path)
+	if info.dur <= 0 && info.sr != "" && path != "-" {
+		if pyr := pyramid(path); pyr != nil {
+			info.dur = float64(pyr.Frames) / float64(pyr.Rate)
+		} else {
+			info.dur = float64(len(decode(path))) / decodeRate
+		}
+		infos[path] = info
+	}
+	return info
+}
+
+// probeInfo is the format of path as far as its header tells.
+func probeInfo(path string) audioInfo {
+	if path == "-" {
+		return stdin.info
 	}
+	if info, ok := infos[path]; ok {
+		return info
+	}
+	var info audioInfo
+	if f, _, err := audio.Probe(path); err == nil {
+		info = formatInfo(f)
+	}
+	infos[path] = info
+	return info
+}
+
+// formatInfo is what aw shows of a format probed by the audio package.
+func formatInfo(f audio.Format) audioInfo {
+	return audioInfo{
+		sr:   strconv.Itoa(f.Rate),
+		ch:   strconv.Itoa(f.Channels),
+		bits: strconv.Itoa(f.Bits),
+		dur:  f.Duration(),
+	}
+}
+
+// parseInfo reads the fields aw uses from sox --i output, for streams on
+// stdin that only sox can read.
+func parseInfo(out []byte) audioInfo {
 	var info audioInfo
 	for _, line := range strings.Split(string(out), "\n") {
 		parts := strings.SplitN(line, ":", 2)
@@ -136,6 +291,7 @@ func fmtDur(s float64) string {
 
 type cacheMeta struct {
 	BPM, Pitch string
+	fields     map[string]string // every cached column, by cacheCols name
 }
 
 func cacheFile(dirpath string) string {
@@ -148,6 +304,36 @@ func cacheFile(dirpath string) string {
 	return filepath.Join(dir, "alf", fmt.Sprintf("%x.tsv", h[:8]))
 }
 
+// peakFile is where alf-index keeps the peak pyramid of path.
+func peakFile(path string) string {
+	return filepath.Join(strings.TrimSuffix(cacheFile(filepath.Dir(path)), ".tsv"), filepath.Base(path)+".peaks")
+}
+
+// pyramids memoizes pyramid; nil means there is none.
+var pyramids = map[string]*audio.Pyramid{}
+
+// pyramid is the peak pyramid alf-index made of path, or nil if there is
+// none or path has changed since. The backend that decoded it comes from
+// the cache.
+func pyramid(path string) *audio.Pyramid {
+	if path == "-" {
+		return nil
+	}
+	if p, ok := pyramids[path]; ok {
+		return p
+	}
+	p, err := audio.ReadPyramid(peakFile(path), path)
+	if err != nil {
+		p = nil
+	} else if b := readCacheMeta(path).fields["backend"]; b != "" {
+		if _, ok := backends[path]; !ok {
+			backends[path] = b
+		}
+	}
+	pyramids[path] = p
+	return p
+}
+
 func readCacheMeta(path string) cacheMeta {
 	dirpath := filepath.Dir(path)
 	name := filepath.Base(path)
@@ -161,7 +347,13 @@ func readCacheMeta(path string) cacheMeta {
 	records, _ := r.ReadAll()
 	for _, rec := range records {
 		if len(rec) >= 3 && rec[0] == name {
-			return cacheMeta{BPM: rec[1], Pitch: rec[2]}
+			fields := map[string]string{}
+			for i, col := range cacheCols {
+				if i+1 < len(rec) {
+					fields[col] = rec[i+1]
+				}
+			}
+			return cacheMeta{BPM: rec[1], Pitch: rec[2], fields: fields}
 		}
 	}
 	return cacheMeta{}
@@ -185,56 +377,253 @@ func readDirCache(dirpath string) map[string]cacheMeta {
 	return cache
 }
 
-func renderFull(path string, width, height int, pos float64) string {
-	samples := decode(path)
-	if samples == nil {
-		return "  [no audio data]"
+// view is a decoded file ready to draw: one sample slice per lane (a single
+// mono mixdown unless -l), and the zoom window of them. A mono view that
+// alf-index has a fine enough peak pyramid for is drawn from that instead,
+// with no samples at all.
+type view struct {
+	path     string
+	info     audioInfo
+	full     [][]int16
+	chans    [][]int16 // full cut to from..to
+	pyr      *audio.Pyramid
+	dur      float64
+	from, to float64
+	zoomed   bool
+	reduced  bool // full is a min/max envelope to fit -maxmem
+}
+
+// loadView gets path ready to draw at up to width columns.
+func loadView(path string, pos float64, width int) *view {
+	v := &view{path: path, info: probeInfo(path)}
+	nch, _ := strconv.Atoi(v.info.ch)
+	if !opts.lanes || nch < 1 {
+		nch = 1
+	}
+	// band colors need the samples
+	if pyr := pyramid(path); pyr != nil && nch == 1 && opts.color == "" {
+		v.dur = v.info.dur
+		if v.dur <= 0 {
+			v.dur = float64(pyr.Frames) / float64(pyr.Rate)
+			v.info.dur = v.dur
+		}
+		v.from, v.to, v.zoomed = window(v.dur, pos)
+		n := float64(pyr.Frames)
+		if pyr.Resolves(int64(v.from*n), int64(v.to*n), width) {
+			v.pyr = pyr
+			return v
+		}
+	}
+	switch {
+	case path != "-" && overBudget(v.info, nch):
+		v.full, v.reduced = envelope(path, nch, width), true
+	case nch > 1:
+		v.full = decodeChannels(path, nch)
+	default:
+		if samples := decode(path); samples != nil {
+			v.full = [][]int16{samples}
+		}
+	}
+	if v.full == nil {
+		return nil
+	}
+	v.dur = v.info.dur
+	if v.dur <= 0 {
+		v.dur = float64(len(v.full[0])) / decodeRate
+		v.info.dur = v.dur
+	}
+	v.from, v.to, v.zoomed = window(v.dur, pos)
+	v.chans = make([][]int16, len(v.full))
+	for c, samples := range v.full {
+		n := len(samples)
+		v.chans[c] = samples[int(v.from*float64(n)):int(v.to*float64(n))]
+	}
+	return v
+}
+
+// drawCols is how many peak columns width cells draw: braille has two
+// dots across each.
+func drawCols(width int) int {
+	if opts.braille {
+		return width * 2
+	}
+	return width
+}
+
+// lanes is how many lanes the view has.
+func (v *view) lanes() int {
+	if v.pyr != nil {
+		return 1
+	}
+	return len(v.chans)
+}
+
+// peaks buckets lane c of the zoom window into width columns.
+func (v *view) peaks(c, width int) []peak {
+	if v.pyr != nil {
+		n := float64(v.pyr.Frames)
+		return toPeaks(v.pyr.Columns(int64(v.from*n), int64(v.to*n), width))
 	}
-	peaks := makePeaks(samples, width)
+	return makePeaks(v.chans[c], width)
+}
+
+// bands colors lane c of the zoom window across width columns.
+func (v *view) bands(c, width int) []rgb {
+	return bandColors(bandLane(v.path, c, len(v.full), v.from, v.to), width)
+}
+
+// overview buckets every lane of the whole file into width columns.
+func (v *view) overview(width int) [][]peak {
+	if v.pyr != nil {
+		return [][]peak{toPeaks(v.pyr.Columns(0, v.pyr.Frames, width))}
+	}
+	var lanes [][]peak
+	for _, samples := range v.full {
+		lanes = append(lanes, makePeaks(samples, width))
+	}
+	return lanes
+}
+
+// split is the column where the playhead at pos falls in a view width wide.
+func (v *view) split(pos float64, width int) int {
+	if pos < 0 {
+		return -1
+	}
+	return max(int((pos-v.from)/(v.to-v.from)*float64(width)), 0)
+}
+
+// ref is the amplitude reference shared by every lane at the given width.
+func (v *view) ref(width int) int16 {
 	var mx int16
-	for _, p := range peaks {
-		if p > mx {
-			mx = p
+	for c := range v.lanes() {
+		for _, p := range v.peaks(c, width) {
+			mx = max(mx, p.abs())
 		}
 	}
-	if mx == 0 {
-		mx = 1
+	return reference(mx)
+}
+
+func renderFull(path string, width, height int, pos float64) string {
+	v := loadView(path, pos, drawCols(width))
+	if v == nil {
+		return "  [no audio data]"
 	}
+	// a lane needs a row: with fewer rows than channels, only the first show
+	nlanes := min(v.lanes(), max(height, 1))
+	info, dur, from, to := v.info, v.dur, v.from, v.to
 
-	split := -1
-	if pos >= 0 {
-		split = int(pos * float64(width))
+	// lanes: label column on the left, one amplitude scale for all channels
+	labels := laneLabels(v.lanes())
+	labels = labels[:min(len(labels), nlanes)]
+	labelW := 0
+	for _, l := range labels {
+		labelW = max(labelW, len(l)+1)
 	}
+	waveW := width - labelW
+	laneH := max(1, height/nlanes)
 
-	var sb strings.Builder
-	info := getInfo(path)
-	cmeta := readCacheMeta(path)
-	name := filepath.Base(path)
+	split := v.split(pos, waveW)
+	mx := v.ref(waveW)
 
-	// build tag string from cache
-	tags := ""
-	if cmeta.BPM != "" {
-		tags += "  " + cmeta.BPM + "bpm"
+	// zoom: overview of the whole file, then only the window below it
+	var sb strings.Builder
+	hdr := header(path, info, pos)
+	sb.WriteString(hdr)
+	if v.zoomed {
+		sb.WriteString(fmt.Sprintf("  %s-%s\n", fmtDur(from*dur), fmtDur(to*dur)))
+		sb.WriteString(overviewLine(v.overview(width), width, from, to, pos) + "\n")
+	} else if hdr != "" {
+		sb.WriteByte('\n')
 	}
-	if cmeta.Pitch != "" {
-		if note := hzToNote(cmeta.Pitch); note != "" {
-			tags += "  " + note
+	if m := readMarkers(path); !m.empty() {
+		sb.WriteString(strings.Repeat(" ", labelW))
+		writeRow(&sb, markerRow(m, from, to, waveW), split)
+		sb.WriteByte('\n')
+	}
+
+	for c := range nlanes {
+		rows := peakRows(func(w int) []peak { return v.peaks(c, w) }, mx, waveW, laneH)
+		var colors []rgb
+		if opts.color != "" && !v.reduced {
+			colors = v.bands(c, waveW)
+		}
+		for r, row := range rows {
+			if labelW > 0 {
+				label := ""
+				if r == 0 {
+					label = labels[c]
+				}
+				sb.WriteString(fmt.Sprintf("%-*s", labelW, label))
+			}
+			if colors != nil {
+				writeColorRow(&sb, row, split, colors)
+			} else {
+				writeRow(&sb, row, split)
+			}
+			if c < nlanes-1 || r < len(rows)-1 {
+				sb.WriteByte('\n')
+			}
 		}
 	}
+	if opts.grid {
+		bpm, _ := strconv.ParseFloat(readCacheMeta(path).BPM, 64)
+		sb.WriteString("\n" + strings.Repeat(" ", labelW))
+		writeRow(&sb, rulerRow(bpm, dur, from, to, waveW), split)
+	}
+	return sb.String()
+}
 
-	if pos >= 0 {
-		cur := info.dur * pos
-		sb.WriteString(fmt.Sprintf("  %s  %sb %sHz %sch  [%s / %s]%s\n",
-			name, info.bits, info.sr, info.ch, fmtDur(cur), fmtDur(info.dur), tags))
-	} else {
-		sb.WriteString(fmt.Sprintf("  %s  %sb %sHz %sch  [%s]%s\n",
-			name, info.bits, info.sr, info.ch, fmtDur(info.dur), tags))
+// laneLabels names the channel lanes: L/R for stereo, 1..N otherwise.
+// A single lane gets no label.
+func laneLabels(nch int) []string {
+	switch {
+	case nch < 2:
+		return nil
+	case nch == 2:
+		return []string{"L", "R"}
+	}
+	labels := make([]string, nch)
+	for i := range labels {
+		labels[i] = strconv.Itoa(i + 1)
+	}
+	return labels
+}
+
+// waveRows draws one lane in the selected style, top row first.
+func waveRows(samples []int16, mx int16, width, height int) [][]rune {
+	return peakRows(func(w int) []peak { return makePeaks(samples, w) }, mx, width, height)
+}
+
+// peakRows is waveRows from peaks, which buckets the lane into the
+// columns asked for: braille draws two per cell.
+func peakRows(peaks func(width int) []peak, mx int16, width, height int) [][]rune {
+	switch {
+	case opts.braille && opts.mirror:
+		return brailleMirrorRows(peaks(width*2), mx, height)
+	case opts.braille:
+		return brailleRows(peaks(width*2), mx, height)
+	case opts.mirror:
+		return mirrorRows(peaks(width), mx, height)
+	}
+	return blockRows(peaks(width), mx, height)
+}
+
+// blockRows draws peaks as a bar graph, one column per cell, top row first.
+func blockRows(peaks []peak, mx int16, height int) [][]rune {
+	levels := make([]float64, len(peaks))
+	for i, p := range peaks {
+		levels[i] = amp(float64(p.abs()), mx)
 	}
+	return barRows(levels, height)
+}
 
+// barRows draws levels (0..1 of the height) as block bars, top row first.
+func barRows(levels []float64, height int) [][]rune {
+	rows := make([][]rune, 0, height)
 	for row := height - 1; row >= 0; row-- {
-		chars := make([]rune, width)
-		for i, p := range peaks {
-			level := float64(p) / float64(mx) * float64(height)
+		chars := make([]rune, len(levels))
+		for i, l := range levels {
+			level := l * float64(height)
 			if level >= float64(row+1) {
 				chars[i] = '█'
 			} else if level > float64(row) {
@@ -247,56 +636,83 @@ func renderFull(path string, width, height int, pos float64) string {
 				chars[i] = ' '
 			}
 		}
-		if split >= 0 && split < width {
-			sb.WriteString(DIM)
-			sb.WriteString(string(chars[:split]))
-			sb.WriteString(BRIGHT)
-			sb.WriteString(string(chars[split:]))
-			sb.WriteString(RST)
-		} else if split >= width {
-			sb.WriteString(DIM)
-			sb.WriteString(string(chars))
-			sb.WriteString(RST)
-		} else {
-			sb.WriteString(string(chars))
-		}
-		if row > 0 {
-			sb.WriteByte('\n')
-		}
+		rows = append(rows, chars)
 	}
-	return sb.String()
+	return rows
 }
 
-func renderSparkline(path string, width int) (string, string, float64) {
-	samples := decode(path)
-	if samples == nil {
+// writeRow writes one grid row, dimming every cell left of split (the played part).
+func writeRow(sb *strings.Builder, chars []rune, split int) {
+	width := len(chars)
+	if split >= 0 && split < width {
+		sb.WriteString(DIM)
+		sb.WriteString(string(chars[:split]))
+		sb.WriteString(BRIGHT)
+		sb.WriteString(string(chars[split:]))
+		sb.WriteString(RST)
+	} else if split >= width {
+		sb.WriteString(DIM)
+		sb.WriteString(string(chars))
+		sb.WriteString(RST)
+	} else {
+		sb.WriteString(string(chars))
+	}
+}
+
+// renderSparkline draws path as one line of blocks. draw, if set, is shown
+// the line so far while a long file streams.
+func renderSparkline(path string, width int, draw func(string)) (string, string, float64) {
+	var partial func([]peak)
+	if draw != nil {
+		partial = func(p []peak) { draw(sparkline(p, nil)) }
+	}
+	peaks := sparkPeaks(path, width, partial)
+	if peaks == nil {
 		return strings.Repeat("▁", width), "", 0
 	}
-	peaks := makePeaks(samples, width)
+	info := getInfo(path)
+	meta := fmt.Sprintf("%sb %sHz %sch", info.bits, info.sr, info.ch)
+	var colors []rgb
+	if opts.color != "" {
+		colors = bandColors(pcm(path, bandRate, true), width)
+	}
+	return sparkline(peaks, colors), meta, info.dur
+}
+
+func sparkline(peaks []peak, colors []rgb) string {
 	var mx int16
 	for _, p := range peaks {
-		if p > mx {
-			mx = p
-		}
-	}
-	if mx == 0 {
-		mx = 1
+		mx = max(mx, p.abs())
 	}
+	mx = reference(mx)
 	var sb strings.Builder
-	for _, p := range peaks {
-		lvl := float64(p) / float64(mx)
-		idx := int(lvl * float64(len(blocks)-1))
+	last := ""
+	for i, p := range peaks {
+		if colors != nil {
+			if esc := colors[i].esc(false); esc != last {
+				sb.WriteString(esc)
+				last = esc
+			}
+		}
+		lvl := amp(float64(p.abs()), mx)
+		if opts.mirror {
+			// the body of the column rather than its outlier
+			lvl = amp(p.rms*math.Sqrt2, mx)
+		}
+		idx := min(int(lvl*float64(len(blocks)-1)), len(blocks)-1)
 		sb.WriteRune(blocks[idx])
 	}
-	info := getInfo(path)
-	meta := fmt.Sprintf("%sb %sHz %sch", info.bits, info.sr, info.ch)
-	return sb.String(), meta, info.dur
+	if colors != nil {
+		sb.WriteString(RST)
+	}
+	return sb.String()
 }
 
-func renderDir(dirpath string, width, maxfiles int) string {
+// audioFiles lists the audio files in dirpath, sorted by name.
+func audioFiles(dirpath string) ([]string, error) {
 	entries, err := os.ReadDir(dirpath)
 	if err != nil {
-		return "  [error reading dir]"
+		return nil, err
 	}
 	var files []string
 	for _, e := range entries {
@@ -308,10 +724,18 @@ func renderDir(dirpath string, width, maxfiles int) string {
 			files = append(files, e.Name())
 		}
 	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func renderDir(dirpath string, width, maxfiles int) string {
+	files, err := audioFiles(dirpath)
+	if err != nil {
+		return "  [error reading dir]"
+	}
 	if len(files) == 0 {
 		return "  [no audio files]"
 	}
-	sort.Strings(files)
 
 	sparkW := width - 40
 	if sparkW < 16 {
@@ -321,6 +745,11 @@ func renderDir(dirpath string, width, maxfiles int) string {
 		sparkW = 30
 	}
 	nameW := width - sparkW - 16
+	specW := 0
+	if opts.spectrum {
+		specW = 8
+		nameW -= specW + 1
+	}
 
 	dcache := readDirCache(dirpath)
 
@@ -329,9 +758,10 @@ func renderDir(dirpath string, width, maxfiles int) string {
 	if limit > maxfiles {
 		limit = maxfiles
 	}
+	shareReference(dirpath, files[:limit], sparkW)
 	for i, f := range files[:limit] {
 		fpath := filepath.Join(dirpath, f)
-		spark, _, dur := renderSparkline(fpath, sparkW)
+		spark, _, dur := renderSparkline(fpath, sparkW, nil)
 		name := f
 		if len(name) > nameW {
 			name = name[:nameW]
@@ -344,6 +774,9 @@ func renderDir(dirpath string, width, maxfiles int) string {
 		if m, ok := dcache[f]; ok && m.BPM != "" {
 			bpm = fmt.Sprintf(" %3sbpm", m.BPM)
 		}
+		if specW > 0 {
+			spark += " " + spectrumSpark(fpath, specW)
+		}
 		sb.WriteString(fmt.Sprintf("  %s%s %s %7s%s", name, strings.Repeat(" ", pad), spark, fmtDur(dur), bpm))
 		if i < limit-1 {
 			sb.WriteByte('\n')
@@ -366,14 +799,7 @@ func renderCombo(path string, width, totalHeight int, pos float64) string {
 	current := filepath.Base(path)
 
 	// list audio files
-	entries, _ := os.ReadDir(dirpath)
-	var files []string
-	for _, e := range entries {
-		if !e.IsDir() && audioExt[strings.ToLower(filepath.Ext(e.Name()))] {
-			files = append(files, e.Name())
-		}
-	}
-	sort.Strings(files)
+	files, _ := audioFiles(dirpath)
 
 	// find current index
 	curIdx := 0
@@ -389,7 +815,22 @@ func renderCombo(path string, width, totalHeight int, pos float64) string {
 	// layout: waveform gets 4 lines + 1 header + 1 separator = 6
 	// sparkline list gets the rest
 	wavH := 3
+	if opts.lanes {
+		if nch, _ := strconv.Atoi(getInfo(path).ch); nch > 1 {
+			wavH = min(2*nch, max(3, totalHeight/2))
+		}
+	}
 	listH := totalHeight - wavH - 3 // -header -separator -spacer
+	// rows renderFull adds around the waveform
+	if !readMarkers(path).empty() {
+		listH--
+	}
+	if opts.grid {
+		listH--
+	}
+	if opts.start.set || opts.end.set {
+		listH-- // overview line
+	}
 	if listH < 3 {
 		listH = 3
 	}
@@ -419,12 +860,19 @@ func renderCombo(path string, width, totalHeight int, pos float64) string {
 		endIdx = len(files)
 	}
 
+	// the waveform below loads the current file anyway; doing that first
+	// lets its sparkline come from the same samples when it has to decode
+	if !opts.spectro {
+		loadView(path, pos, drawCols(width))
+	}
+
 	var sb strings.Builder
+	shareReference(dirpath, files[startIdx:endIdx], sparkW)
 
 	// render sparkline list
 	for _, f := range files[startIdx:endIdx] {
 		fpath := filepath.Join(dirpath, f)
-		spark, _, dur := renderSparkline(fpath, sparkW)
+		spark, _, dur := renderSparkline(fpath, sparkW, nil)
 		name := f
 		if len(name) > nameW {
 			name = name[:nameW]
@@ -443,10 +891,10 @@ func renderCombo(path string, width, totalHeight int, pos float64) string {
 
 		if f == current {
 			sb.WriteString(fmt.Sprintf("%s> %s%s %s %s %s%s\n",
-				SEL, name, strings.Repeat(" ", pad), spark, fmtDur(dur), bpmStr, RST))
+				SEL, name, strings.Repeat(" ", pad), spark+SEL, fmtDur(dur), bpmStr, RST))
 		} else {
 			sb.WriteString(fmt.Sprintf("%s  %s%s %s %s %s%s\n",
-				UNSEL, name, strings.Repeat(" ", pad), spark, fmtDur(dur), bpmStr, RST))
+				UNSEL, name, strings.Repeat(" ", pad), spark+UNSEL, fmtDur(dur), bpmStr, RST))
 		}
 	}
 
@@ -454,7 +902,11 @@ func renderCombo(path string, width, totalHeight int, pos float64) string {
 	sb.WriteString(strings.Repeat("─", width) + "\n")
 
 	// compact waveform of current file
-	sb.WriteString(renderFull(path, width, wavH, pos))
+	if opts.spectro {
+		sb.WriteString(renderSpectrogram(path, width, wavH, pos))
+	} else {
+		sb.WriteString(renderFull(path, width, wavH, pos))
+	}
 
 	return sb.String()
 }
@@ -466,28 +918,265 @@ func main() {
 	dir := flag.Bool("d", false, "directory listing")
 	combo := flag.Bool("c", false, "combo: sparkline list + waveform")
 	pos := flag.Float64("p", -1, "playback position 0.0-1.0")
+	braille := flag.Bool("b", false, "braille mode: 2x4 dots per cell")
+	lanes := flag.Bool("l", false, "one lane per channel")
+	mirror := flag.Bool("m", false, "mirrored min/max waveform with RMS body")
+	color := flag.String("color", "", "color by frequency band: 256, true or auto")
+	spectro := flag.Bool("s", false, "spectrogram")
+	spectrum := flag.Bool("f", false, "average spectrum bar graph (a spectrum column with -d)")
+	diff := flag.Bool("diff", false, "compare two versions of a file: aligned waveforms, difference and stats")
+	align := flag.Bool("align", false, "line up the first transients of several files")
+	vector := flag.Bool("vector", false, "stereo goniometer with phase correlation and width (braille with -b)")
+	logFreq := flag.Bool("logf", false, "log frequency axis for -s")
+	start := flag.String("start", "", "zoom start: fraction 0.0-1.0 or seconds (12s, 1:02.5)")
+	end := flag.String("end", "", "zoom end: fraction 0.0-1.0 or seconds (12s, 1:02.5)")
+	dbRange := flag.Float64("db", 0, "log amplitude scale spanning this many dB (0 = linear)")
+	norm := flag.String("norm", "file", "normalize to: file, shared (one scale for -d/-c) or fs (full scale)")
+	grid := flag.Bool("g", false, "bar/beat grid from the cached BPM, else a seconds ruler")
+	markers := flag.Bool("markers", false, "list WAV cue points and sampler loops")
+	pngOut := flag.String("png", "", "write a PNG waveform to this file (- for stdout)")
+	svgOut := flag.String("svg", "", "write an SVG waveform to this file (- for stdout)")
+	size := flag.String("size", "800x200", "image size for -png/-svg, WxH pixels")
+	fg := flag.String("fg", "#66bb6a", "image waveform color")
+	bg := flag.String("bg", "#1a1a1a", "image background color, or none")
+	played := flag.String("played", "#3a3a3a", "image color mixed into the played part")
+	head := flag.String("head", "#ffffff", "image playhead color")
+	gfx := flag.String("gfx", "none", "terminal graphics: auto, sixel, kitty, iterm or none")
+	at := flag.String("at", "", "draw kitty/iterm graphics on the terminal at cell X,Y (lf previewer; exits 1 after graphics so lf doesn't cache them)")
+	clear := flag.Bool("clear", false, "delete kitty graphics and exit (lf cleaner)")
+	jsonOut := flag.Bool("json", false, "print info, cached tags and peaks as JSON")
+	typ := flag.String("type", "", "sox file type of audio on stdin (default: from its header)")
+	rate := flag.Int("rate", 0, "raw PCM on stdin: sample rate")
+	chans := flag.Int("chans", 0, "raw PCM on stdin: channel count")
+	scope := flag.Bool("scope", false, "scrolling live scope of a stream on stdin (-) or a FIFO, with level meters")
+	headerTmpl := flag.String("header", defaultHeader, "header line template: {name} {path} {dur} {time} {pos} {rate} {bits} {ch} {bpm} {note} {size} {backend} {cache.COLUMN}..., {key:-12.12} width, {?key}..{/} {!key}..{/} conditionals, empty for none")
+	followSrc := flag.String("follow", "", "redraw as playback moves: mpd (alf-play), or a position file, FIFO or socket")
+	enc := flag.String("enc", "s16", "raw PCM on stdin: u8, s8, s16, s24, s32, f32, f64, ulaw or alaw, le/be suffix")
+	maxTime := flag.Duration("maxtime", 0, "stop decoding a file after this long (e.g. 2s) and draw what there is")
+	maxMem := flag.String("maxmem", "", "decode longer files to a min/max envelope that fits this many bytes (e.g. 64M)")
 	flag.Parse()
 
+	var err error
+	opts.braille = *braille
+	opts.lanes = *lanes
+	opts.mirror = *mirror
+	opts.spectro = *spectro
+	opts.spectrum = *spectrum
+	opts.logFreq = *logFreq
+	opts.dbRange = *dbRange
+	opts.grid = *grid
+	opts.maxTime = *maxTime
+	switch *norm {
+	case "file", "shared", "fs":
+		opts.norm = *norm
+	default:
+		fmt.Fprintf(os.Stderr, "aw: unknown -norm %q (want file, shared or fs)\n", *norm)
+		os.Exit(1)
+	}
+	if opts.header, err = parseHeader(*headerTmpl); err != nil {
+		fmt.Fprintf(os.Stderr, "aw: -%v\n", err)
+		os.Exit(1)
+	}
+	opts.headerBackend = usesKey(opts.header, "backend")
+	if opts.start, err = parseTimeSpec(*start); err != nil {
+		fmt.Fprintf(os.Stderr, "aw: -start: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.end, err = parseTimeSpec(*end); err != nil {
+		fmt.Fprintf(os.Stderr, "aw: -end: %v\n", err)
+		os.Exit(1)
+	}
+	if *maxMem != "" {
+		if opts.maxMem, err = parseBytes(*maxMem); err != nil {
+			fmt.Fprintf(os.Stderr, "aw: -maxmem: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	switch *color {
+	case "", "256", "true":
+		opts.color = *color
+	case "auto":
+		opts.color = "256"
+		if ct := os.Getenv("COLORTERM"); ct == "truecolor" || ct == "24bit" {
+			opts.color = "true"
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "aw: unknown -color %q (want 256, true or auto)\n", *color)
+		os.Exit(1)
+	}
+
+	if *clear {
+		clearGfx()
+		return
+	}
 	if flag.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "usage: aw [flags] <file|dir>")
+		fmt.Fprintln(os.Stderr, "usage: aw [flags] <file|dir|-> | <file> <file>...")
 		os.Exit(1)
 	}
 	path := flag.Arg(0)
+	if *diff && flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "aw: -diff compares exactly two files")
+		fmt.Fprintln(os.Stderr, "usage: aw -diff [flags] <file> <file>")
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 1 {
+		paths := flag.Args()
+		for _, p := range paths {
+			if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+				fmt.Fprintf(os.Stderr, "aw: %s: several arguments must all be files\n", p)
+				os.Exit(1)
+			}
+		}
+		normSet := false
+		flag.Visit(func(f *flag.Flag) { normSet = normSet || f.Name == "norm" })
+		if !normSet {
+			opts.norm = "shared"
+		}
+		switch {
+		case *diff:
+			fmt.Println(renderDiff(paths[0], paths[1], *width, *height))
+		case *jsonOut:
+			var v []jsonFile
+			for _, p := range paths {
+				v = append(v, fileJSON(p, *width, *pos))
+			}
+			if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+				fmt.Fprintf(os.Stderr, "aw: %v\n", err)
+				os.Exit(1)
+			}
+		case *oneline:
+			shareReference("", paths, *width)
+			for _, p := range paths {
+				spark, meta, dur := renderSparkline(p, *width, liveLine())
+				fmt.Printf("%s  %s  %s  %s\n", spark, fmtDur(dur), meta, displayName(p))
+			}
+		default:
+			fmt.Println(renderStack(paths, *width, *height, *align))
+		}
+		return
+	}
+
+	if *scope {
+		if err := renderScope(path, *width, *height, *typ, rawFormat{*rate, *chans, *enc}); err != nil {
+			fmt.Fprintf(os.Stderr, "aw: -scope: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	fi, err := os.Stat(path)
+	isDir := false
+	if path == "-" {
+		if *dir || *combo {
+			fmt.Fprintln(os.Stderr, "aw: -d and -c need a file or directory, not stdin")
+			os.Exit(1)
+		}
+		if err := readStdin(*typ, rawFormat{*rate, *chans, *enc}); err != nil {
+			fmt.Fprintf(os.Stderr, "aw: stdin: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fi, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "aw: %v\n", err)
+			os.Exit(1)
+		}
+		isDir = fi.IsDir()
+	}
+
+	imgOpts, err := imageOptions(*size, *fg, *bg, *played, *head)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "aw: %v\n", err)
 		os.Exit(1)
 	}
+	proto := *gfx
+	switch proto {
+	case "auto":
+		proto = detectGfx()
+	case "none":
+		proto = ""
+	case gfxSixel, gfxKitty, gfxITerm:
+	default:
+		fmt.Fprintf(os.Stderr, "aw: unknown -gfx %q (want auto, sixel, kitty, iterm or none)\n", proto)
+		os.Exit(1)
+	}
+
+	// show prints a frame at -p, or redraws it as playback moves with -follow
+	show := func(draw func(pos float64) string) {
+		if *followSrc == "" {
+			fmt.Print(draw(*pos))
+			return
+		}
+		src, err := openFollow(*followSrc, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "aw: -follow: %v\n", err)
+			os.Exit(1)
+		}
+		follow(src, getInfo(path).dur, draw)
+	}
 
-	if *dir || fi.IsDir() {
+	if *pngOut != "" || *svgOut != "" {
+		out, format := *pngOut, "png"
+		if *svgOut != "" {
+			out, format = *svgOut, "svg"
+		}
+		if err := writeImage(path, out, format, imgOpts, *pos); err != nil {
+			fmt.Fprintf(os.Stderr, "aw: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *jsonOut {
+		var v any
+		switch {
+		case *dir || isDir:
+			v = dirJSON(path, "", *width)
+		case *combo:
+			v = dirJSON(filepath.Dir(path), filepath.Base(path), *width)
+		default:
+			v = fileJSON(path, *width, *pos)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+			fmt.Fprintf(os.Stderr, "aw: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *markers {
+		fmt.Print(dumpMarkers(path))
+	} else if *dir || isDir {
 		fmt.Print(renderDir(path, *width, 50))
 	} else if *combo {
-		fmt.Print(renderCombo(path, *width, *height, *pos))
+		show(func(p float64) string { return renderCombo(path, *width, *height, p) })
 	} else if *oneline {
-		spark, meta, dur := renderSparkline(path, *width)
+		spark, meta, dur := renderSparkline(path, *width, liveLine())
 		fmt.Printf("%s  %s  %s\n", spark, fmtDur(dur), meta)
+	} else if *spectro {
+		fmt.Print(renderSpectrogram(path, *width, *height, *pos))
+	} else if *vector {
+		fmt.Print(renderVector(path, *width, *height))
+	} else if *spectrum {
+		fmt.Print(renderSpectrum(path, *width, *height))
+	} else if proto != "" {
+		hdr, img := renderGfx(path, *width, *height, *pos, proto, imgOpts)
+		if hdr != "" {
+			fmt.Println(hdr)
+		}
+		var x, y int
+		if _, err := fmt.Sscanf(*at, "%d,%d", &x, &y); err == nil && proto != gfxSixel {
+			// text header on stdout, image on the terminal below it
+			if hdr != "" {
+				y++
+			}
+			if err := placeGfx(img, x, y); err != nil {
+				fmt.Fprintf(os.Stderr, "aw: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Print(img)
+		}
+		if *at != "" {
+			// lf caches a preview that exits 0, and an image has to be
+			// drawn again every time it is shown
+			os.Exit(1)
+		}
 	} else {
-		fmt.Print(renderFull(path, *width, *height, *pos))
+		show(func(p float64) string { return renderFull(path, *width, *height, p) })
 	}
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// cue is a WAV cue point: a marker or slice start, in sample frames.
+type cue struct {
+	id    uint32
+	pos   int64
+	label string
+}
+
+// sampleLoop is a loop from a smpl chunk, in sample frames (end inclusive).
+type sampleLoop struct {
+	id         uint32
+	kind       uint32 // 0 forward, 1 ping-pong, 2 backward
+	start, end int64
+	count      uint32 // 0 = infinite
+}
+
+var loopKinds = []string{"forward", "ping-pong", "backward"}
+
+// maxMarkerChunk bounds the fmt, cue, smpl and LIST chunks readMarkers
+// reads into memory; a larger one is skipped. A megabyte holds tens of
+// thousands of cue points.
+const maxMarkerChunk = 1 << 20
+
+func (l sampleLoop) kindName() string {
+	if int(l.kind) < len(loopKinds) {
+		return loopKinds[l.kind]
+	}
+	return fmt.Sprintf("type %d", l.kind)
+}
+
+type wavMarkers struct {
+	rate   int
+	frames int64
+	cues   []cue
+	loops  []sampleLoop
+}
+
+func (m wavMarkers) empty() bool { return len(m.cues) == 0 && len(m.loops) == 0 }
+
+// readMarkers reads the cue, smpl and LIST/adtl label chunks of a RIFF WAVE
+// file, seeking past the audio. Anything else returns no markers.
+func readMarkers(path string) wavMarkers {
+	var m wavMarkers
+	var f io.ReadSeeker
+	if path == "-" {
+		f = bytes.NewReader(stdin.data)
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return m
+		}
+		defer file.Close()
+		f = file
+	}
+	var hdr [12]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil ||
+		string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WAVE" {
+		return m
+	}
+	labels := map[uint32]string{}
+	blockAlign := 0
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(f, ch[:]); err != nil {
+			break
+		}
+		id := string(ch[0:4])
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		next := size + size&1
+		if id == "data" {
+			if blockAlign > 0 {
+				m.frames = size / int64(blockAlign)
+			}
+			if _, err := f.Seek(next, io.SeekCurrent); err != nil {
+				break
+			}
+			continue
+		}
+		// the size comes from the file: skip what no marker chunk would need
+		if id != "fmt " && id != "cue " && id != "smpl" && id != "LIST" || size > maxMarkerChunk {
+			if _, err := f.Seek(next, io.SeekCurrent); err != nil {
+				break
+			}
+			continue
+		}
+		body := make([]byte, next)
+		if _, err := io.ReadFull(f, body); err != nil {
+			break
+		}
+		body = body[:size]
+		switch id {
+		case "fmt ":
+			if len(body) >= 14 {
+				m.rate = int(binary.LittleEndian.Uint32(body[4:8]))
+				blockAlign = int(binary.LittleEndian.Uint16(body[12:14]))
+			}
+		case "cue ":
+			m.cues = parseCues(body)
+		case "smpl":
+			m.loops = parseLoops(body)
+		case "LIST":
+			parseLabels(body, labels)
+		}
+	}
+	for i := range m.cues {
+		m.cues[i].label = labels[m.cues[i].id]
+	}
+	return m
+}
+
+func parseCues(b []byte) []cue {
+	if len(b) < 4 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(b[0:4]))
+	var cues []cue
+	for i := 0; i < n && 4+(i+1)*24 <= len(b); i++ {
+		c := b[4+i*24:]
+		cues = append(cues, cue{
+			id:  binary.LittleEndian.Uint32(c[0:4]),
+			pos: int64(binary.LittleEndian.Uint32(c[20:24])), // sample offset
+		})
+	}
+	return cues
+}
+
+func parseLoops(b []byte) []sampleLoop {
+	if len(b) < 36 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(b[28:32]))
+	var loops []sampleLoop
+	for i := 0; i < n && 36+(i+1)*24 <= len(b); i++ {
+		l := b[36+i*24:]
+		loops = append(loops, sampleLoop{
+			id:    binary.LittleEndian.Uint32(l[0:4]),
+			kind:  binary.LittleEndian.Uint32(l[4:8]),
+			start: int64(binary.LittleEndian.Uint32(l[8:12])),
+			end:   int64(binary.LittleEndian.Uint32(l[12:16])),
+			count: binary.LittleEndian.Uint32(l[20:24]),
+		})
+	}
+	return loops
+}
+
+// parseLabels collects labl and note texts from a LIST/adtl chunk.
+func parseLabels(b []byte, labels map[uint32]string) {
+	if len(b) < 4 || string(b[0:4]) != "adtl" {
+		return
+	}
+	for b = b[4:]; len(b) >= 8; {
+		id := string(b[0:4])
+		size := int(binary.LittleEndian.Uint32(b[4:8]))
+		if 8+size > len(b) {
+			return
+		}
+		if (id == "labl" || id == "note") && size >= 4 {
+			cueID := binary.LittleEndian.Uint32(b[8:12])
+			text := strings.TrimRight(string(b[12:8+size]), "\x00")
+			if _, ok := labels[cueID]; !ok || id == "labl" {
+				labels[cueID] = text
+			}
+		}
+		b = b[min(8+size+size&1, len(b)):]
+	}
+}
+
+// summary is the marker count for the header, e.g. "  8cue  1loop".
+func (m wavMarkers) summary() string {
+	s := ""
+	if len(m.cues) > 0 {
+		s += fmt.Sprintf("  %dcue", len(m.cues))
+	}
+	if len(m.loops) > 0 {
+		s += fmt.Sprintf("  %dloop", len(m.loops))
+	}
+	return s
+}
+
+func (m wavMarkers) secs(frame int64) float64 {
+	if m.rate == 0 {
+		return 0
+	}
+	return float64(frame) / float64(m.rate)
+}
+
+// markerRow is a line of width cells over the window from..to: loops as
+// ┣━━┫ spans and cue points as ▼.
+func markerRow(m wavMarkers, from, to float64, width int) []rune {
+	row := make([]rune, width)
+	for i := range row {
+		row[i] = ' '
+	}
+	if m.frames == 0 || to <= from {
+		return row
+	}
+	col := func(frame int64) int {
+		return int((float64(frame)/float64(m.frames) - from) / (to - from) * float64(width))
+	}
+	for _, l := range m.loops {
+		a, b := col(l.start), col(l.end)
+		for x := max(a, 0); x <= min(b, width-1); x++ {
+			row[x] = '━'
+		}
+		if a >= 0 && a < width {
+			row[a] = '┣'
+		}
+		if b >= 0 && b < width && b > a {
+			row[b] = '┫'
+		}
+	}
+	for _, c := range m.cues {
+		if x := col(c.pos); x >= 0 && x < width {
+			row[x] = '▼'
+		}
+	}
+	return row
+}
+
+// dumpMarkers lists cue points and loops, one per line.
+func dumpMarkers(path string) string {
+	m := readMarkers(path)
+	if m.empty() {
+		return ""
+	}
+	var sb strings.Builder
+	for _, c := range m.cues {
+		sb.WriteString(fmt.Sprintf("cue   %3d  %9.3fs  %9d  %s\n", c.id, m.secs(c.pos), c.pos, c.label))
+	}
+	for _, l := range m.loops {
+		count := "inf"
+		if l.count > 0 {
+			count = fmt.Sprintf("x%d", l.count)
+		}
+		sb.WriteString(fmt.Sprintf("loop  %3d  %9.3fs  %9d  %9.3fs  %9d  %s %s\n",
+			l.id, m.secs(l.start), l.start, m.secs(l.end), l.end, l.kindName(), count))
+	}
+	return sb.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+// mirrorRows draws peaks mirrored around a zero line: max above, min below,
+// each half scaled to the rows it has, so an odd height gives the top one
+// more row but neither half clips. The RMS level is the solid body; the
+// peak envelope beyond it is shaded, so dense and spiky material look
+// different.
+func mirrorRows(peaks []peak, mx int16, height int) [][]rune {
+	if height == 1 {
+		return [][]rune{mirrorLine(peaks, mx)}
+	}
+	up := (height + 1) / 2
+	top, bottom := float64(up), float64(height-up)
+	rows := make([][]rune, height)
+	for r := range rows {
+		rows[r] = make([]rune, len(peaks))
+	}
+	for i, p := range peaks {
+		hi := amp(float64(p.max), mx)
+		lo := amp(-float64(p.min), mx)
+		body := amp(p.rms, mx)
+		for d := range up {
+			rows[up-1-d][i] = mirrorCell(body*top-float64(d), hi*top-float64(d), d == 0, '▄', '▁')
+		}
+		for d := range height - up {
+			rows[up+d][i] = mirrorCell(body*bottom-float64(d), lo*bottom-float64(d), d == 0, '▀', '▔')
+		}
+	}
+	return rows
+}
+
+// mirrorLine is mirrorRows one row high, where both halves share a cell:
+// glyphs centered on the zero line grow with the level on either side.
+func mirrorLine(peaks []peak, mx int16) []rune {
+	row := make([]rune, len(peaks))
+	for i, p := range peaks {
+		env := max(amp(float64(p.max), mx), amp(-float64(p.min), mx))
+		body := amp(p.rms, mx)
+		switch {
+		case body >= 0.5:
+			row[i] = '█'
+		case env >= 0.5:
+			row[i] = '░'
+		case body >= 0.125:
+			row[i] = '━'
+		default:
+			row[i] = '─'
+		}
+	}
+	return row
+}
+
+// mirrorCell picks the glyph for a cell filled body/env of the way from the
+// zero line side. halfRune and edgeRune are the half and eighth blocks on
+// that side; edgeRune also marks the zero line. An envelope reaching past
+// the middle of the cell wins over a body that doesn't, so the peaks still
+// show where the body ends.
+func mirrorCell(body, env float64, zero bool, halfRune, edgeRune rune) rune {
+	switch {
+	case body >= 1:
+		return '█'
+	case body >= 0.5:
+		return halfRune
+	case env >= 0.5:
+		return '░'
+	case body >= 0.125:
+		return edgeRune
+	case zero:
+		return edgeRune
+	}
+	return ' '
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+)
+
+// amp maps an amplitude to 0..1 of the drawing height against ref, either
+// linearly or, with -db, in decibels down to -opts.dbRange.
+func amp(v float64, ref int16) float64 {
+	x := v / float64(ref)
+	if opts.dbRange > 0 {
+		if x <= 0 {
+			return 0
+		}
+		x = 1 + 20*math.Log10(x)/opts.dbRange
+	}
+	return min(max(x, 0), 1)
+}
+
+// reference is the peak a view with loudest peak mx is drawn against.
+func reference(mx int16) int16 {
+	switch {
+	case opts.norm == "fs":
+		return math.MaxInt16
+	case opts.norm == "shared" && opts.sharedPeak > 0:
+		return opts.sharedPeak
+	case mx == 0:
+		return 1
+	}
+	return mx
+}
+
+// shareReference sets the common reference for -norm shared to the loudest
+// of the listed files, so quiet samples look quiet next to loud ones. It
+// goes by the files' sparklines at width, so a file that isn't decoded yet
+// is only streamed once for both.
+func shareReference(dirpath string, files []string, width int) {
+	if opts.norm != "shared" {
+		return
+	}
+	opts.sharedPeak = 0
+	for _, f := range files {
+		for _, p := range sparkPeaks(filepath.Join(dirpath, f), width, nil) {
+			opts.sharedPeak = max(opts.sharedPeak, p.abs())
+		}
+	}
+}
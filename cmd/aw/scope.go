@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scopeSpan  = 2 * decodeRate // samples across the scope: two seconds
+	scopeFPS   = 30
+	meterFloor = 60 // dBFS at the left end of the meters
+	meterFall  = 20 // dB per second the peak hold falls back
+)
+
+// hblocks are the eighth steps of a horizontal bar.
+var hblocks = []rune(" ▏▎▍▌▋▊▉█")
+
+// scopeState is what the reader has taken off the stream so far: the last
+// stretch of samples and the levels since the previous frame.
+type scopeState struct {
+	mu    sync.Mutex
+	ring  []int16
+	total int64
+	peak  int16
+	sum   float64
+	n     int
+	done  bool
+	err   error
+}
+
+// feed appends decoded samples, dropping all but the last scopeSpan.
+func (s *scopeState) feed(samples []int16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ring = append(s.ring, samples...)
+	if len(s.ring) > 2*scopeSpan {
+		s.ring = append(s.ring[:0], s.ring[len(s.ring)-scopeSpan:]...)
+	}
+	s.total += int64(len(samples))
+	for _, v := range samples {
+		a := v
+		if a < 0 {
+			a = -max(a, -math.MaxInt16)
+		}
+		s.peak = max(s.peak, a)
+		s.sum += float64(v) * float64(v)
+	}
+	s.n += len(samples)
+}
+
+// take returns the window to draw and the peak and RMS (0..1) since the
+// last call, or ok false if nothing new arrived.
+func (s *scopeState) take() (window []int16, pk, rms float64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 {
+		return nil, 0, 0, false
+	}
+	window = make([]int16, scopeSpan)
+	tail := s.ring[max(len(s.ring)-scopeSpan, 0):]
+	copy(window[scopeSpan-len(tail):], tail)
+	pk = float64(s.peak) / math.MaxInt16
+	rms = math.Sqrt(s.sum/float64(s.n)) / math.MaxInt16
+	s.peak, s.sum, s.n = 0, 0, 0
+	return window, pk, rms, true
+}
+
+// renderScope draws a scrolling waveform of the stream in path ("-" for
+// stdin, else a FIFO or a file, read once to its end) with peak and RMS
+// meters below. A reader goroutine drains sox as fast as it decodes and
+// frames are drawn on a ticker from whatever is newest, so a terminal that
+// can't keep up skips frames instead of stalling the pipeline upstream.
+func renderScope(path string, width, height int, typ string, raw rawFormat) error {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	br := bufio.NewReader(in)
+	// a stream shorter than sniffLen is fine: Peek gives what there is
+	head, _ := br.Peek(sniffLen)
+	args, err := streamArgs(head, typ, raw)
+	if err != nil {
+		return err
+	}
+	argv := append([]string{"--buffer", "512"}, args...)
+	argv = append(argv, "-", "-c", "1", "-r", strconv.Itoa(decodeRate), "-b", "16", "-e", "signed-integer", "-t", "raw", "-")
+	cmd := exec.Command("sox", argv...)
+	cmd.Stdin = br
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Process.Kill()
+
+	st := &scopeState{}
+	go func() {
+		buf := make([]byte, 1024)
+		carry := 0
+		for {
+			n, err := out.Read(buf[carry:])
+			n += carry
+			samples := make([]int16, n/2)
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(buf[i*2:]))
+			}
+			carry = copy(buf, buf[n&^1:n])
+			st.feed(samples)
+			if err != nil {
+				st.mu.Lock()
+				st.done = true
+				if err != io.EOF {
+					st.err = err
+				}
+				st.mu.Unlock()
+				return
+			}
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	fmt.Print("\033[?25l")
+	defer fmt.Print("\033[?25h\n")
+
+	name := displayName(path)
+	hold, lines := -math.Inf(1), -1
+	tick := time.NewTicker(time.Second / scopeFPS)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-tick.C:
+		}
+		window, pk, rms, ok := st.take()
+		if ok {
+			pkDB, rmsDB := dbfs(pk), dbfs(rms)
+			hold = max(pkDB, hold-float64(meterFall)/scopeFPS)
+			st.mu.Lock()
+			secs := float64(st.total) / decodeRate
+			st.mu.Unlock()
+			lines = redraw(scopeFrame(name, window, secs, width, height, pkDB, hold, rmsDB), lines)
+		}
+		st.mu.Lock()
+		done, err := st.done && st.n == 0, st.err
+		st.mu.Unlock()
+		if done {
+			return err
+		}
+	}
+}
+
+// scopeFrame is one frame: a header, the waveform against full scale and
+// the meters.
+func scopeFrame(name string, window []int16, secs float64, width, height int, pk, hold, rms float64) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "  %s  scope  [%s]\n", name, fmtDur(secs))
+	for _, chars := range waveRows(window, math.MaxInt16, width, height) {
+		sb.WriteString(string(chars))
+		sb.WriteString("\n")
+	}
+	barW := max((width-26)/2, 4)
+	fmt.Fprintf(&sb, "pk  %s %6s  rms %s %6s", meterBar(pk, hold, barW), fmtDB(pk), meterBar(rms, math.Inf(-1), barW), fmtDB(rms))
+	return sb.String()
+}
+
+// meterBar is a horizontal bar from -meterFloor to 0 dBFS, with a tick at
+// the held peak.
+func meterBar(db, hold float64, width int) string {
+	pos := func(db float64) float64 {
+		return min(max((db+meterFloor)/meterFloor, 0), 1) * float64(width)
+	}
+	level := pos(db)
+	bar := make([]rune, width)
+	for i := range bar {
+		switch {
+		case level >= float64(i+1):
+			bar[i] = '█'
+		case level > float64(i):
+			bar[i] = hblocks[int((level-float64(i))*float64(len(hblocks)-1))]
+		default:
+			bar[i] = ' '
+		}
+	}
+	if h := int(pos(hold)); !math.IsInf(hold, -1) && h > 0 && bar[h-1] == ' ' {
+		bar[h-1] = '▏'
+	}
+	return "▕" + string(bar) + "▏"
+}
+
+// dbfs converts a 0..1 level to decibels below full scale.
+func dbfs(v float64) float64 {
+	if v <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(v)
+}
+
+func fmtDB(db float64) string {
+	switch {
+	case db < -meterFloor:
+		return "-inf"
+	case math.IsInf(db, 1):
+		return "+inf"
+	}
+	return fmt.Sprintf("%.1f", db)
+}
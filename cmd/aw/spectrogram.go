@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	specRate  = 22050 // decode rate for -s, enough for hats and air
+	specFFT   = 1024  // ~21Hz bins at specRate
+	specRange = 80.0  // dB between the quietest and loudest color
+	logFloor  = 40.0  // lowest frequency on the -logf axis
+	maxFrames = 8     // FFT frames averaged per column
+)
+
+// heatmap runs from silence (black) to the loudest bin (pale yellow).
+var heatmap = []rgb{
+	{0, 0, 0},
+	{40, 10, 90},
+	{170, 30, 100},
+	{245, 110, 30},
+	{255, 240, 160},
+}
+
+func heatColor(v float64) rgb {
+	v = math.Max(0, math.Min(1, v)) * float64(len(heatmap)-1)
+	i := min(int(v), len(heatmap)-2)
+	f := v - float64(i)
+	a, b := heatmap[i], heatmap[i+1]
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f) }
+	return rgb{mix(a.r, b.r), mix(a.g, b.g), mix(a.b, b.b)}
+}
+
+// renderSpectrogram draws a time x frequency heatmap. Each cell is a '▀'
+// whose foreground and background are two stacked frequency pixels.
+func renderSpectrogram(path string, width, height int, pos float64) string {
+	samples := pcm(path, specRate, true)
+	if samples == nil {
+		return "  [no audio data]"
+	}
+	info := getInfo(path)
+	dur := info.dur
+	if dur <= 0 {
+		dur = float64(len(samples)) / specRate
+	}
+	from, to, zoomed := window(dur, pos)
+	overview := ""
+	if zoomed {
+		overview = overviewLine([][]peak{makePeaks(samples, width)}, width, from, to, pos)
+		n := len(samples)
+		samples = samples[int(from*float64(n)):int(to*float64(n))]
+	}
+
+	pixH := height * 2
+	grid := spectrogram(samples, width, pixH)
+
+	// normalize to the loudest pixel, specRange dB below it is black
+	top := math.Inf(-1)
+	for _, col := range grid {
+		for _, v := range col {
+			top = math.Max(top, v)
+		}
+	}
+
+	split := -1
+	if pos >= 0 {
+		split = max(int((pos-from)/(to-from)*float64(width)), 0)
+	}
+
+	var sb strings.Builder
+	hdr := header(path, info, pos)
+	sb.WriteString(hdr)
+	if zoomed {
+		sb.WriteString(fmt.Sprintf("  %s-%s\n", fmtDur(from*dur), fmtDur(to*dur)))
+		sb.WriteString(overview + "\n")
+	} else if hdr != "" {
+		sb.WriteByte('\n')
+	}
+	for row := range height {
+		hi := pixH - 1 - row*2
+		for x, col := range grid {
+			dim := x < split
+			sb.WriteString(heatColor(1 - (top-col[hi])/specRange).esc(dim))
+			sb.WriteString(heatColor(1 - (top-col[hi-1])/specRange).bgEsc(dim))
+			sb.WriteRune('▀')
+		}
+		sb.WriteString(RST)
+		if row < height-1 {
+			sb.WriteByte('\n')
+		}
+	}
+	if opts.grid {
+		bpm, _ := strconv.ParseFloat(readCacheMeta(path).BPM, 64)
+		sb.WriteByte('\n')
+		writeRow(&sb, rulerRow(bpm, dur, from, to, width), split)
+	}
+	return sb.String()
+}
+
+// spectrogram returns width columns of pixH levels in dB, lowest frequency
+// first. Each column averages up to maxFrames FFT frames spread over its
+// span; each pixel takes the loudest bin in its frequency range.
+func spectrogram(samples []int16, width, pixH int) [][]float64 {
+	n := len(samples)
+	win := hann(specFFT)
+	nyq := float64(specRate) / 2
+	binHz := float64(specRate) / specFFT
+
+	// frequency edges of every pixel row, as FFT bin ranges
+	edge := func(y int) float64 {
+		t := float64(y) / float64(pixH)
+		if opts.logFreq {
+			return logFloor * math.Pow(nyq/logFloor, t)
+		}
+		return nyq * t
+	}
+	lo := make([]int, pixH)
+	hi := make([]int, pixH)
+	for y := range pixH {
+		lo[y] = int(edge(y) / binHz)
+		hi[y] = max(int(edge(y+1)/binHz), lo[y]+1)
+		hi[y] = min(hi[y], specFFT/2+1)
+		lo[y] = min(lo[y], hi[y]-1)
+	}
+
+	grid := make([][]float64, width)
+	for x := range width {
+		s := x * n / width
+		e := (x + 1) * n / width
+		frames := max(1, min(maxFrames, (e-s)/(specFFT/2)))
+		power := make([]float64, specFFT/2+1)
+		for f := range frames {
+			center := s + (2*f+1)*(e-s)/(2*frames)
+			for k, p := range powerSpectrum(samples, center-specFFT/2, win) {
+				power[k] += p / float64(frames)
+			}
+		}
+		col := make([]float64, pixH)
+		for y := range pixH {
+			var mx float64
+			for _, p := range power[lo[y]:hi[y]] {
+				mx = math.Max(mx, p)
+			}
+			col[y] = 10 * math.Log10(mx+1e-12)
+		}
+		grid[x] = col
+	}
+	return grid
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	spectrumFrames = 256  // FFT frames averaged across the file, at most
+	spectrumRange  = 60.0 // dB below the loudest band shown, unless -db
+)
+
+// freqTicks are the labeled -f frequencies, decades first so they win
+// when labels would collide.
+var freqTicks = []float64{100, 1000, 10000, 50, 200, 500, 2000, 5000, 20, 30, 300, 3000}
+
+// averageSpectrum is the mean power spectrum of evenly spaced FFT frames
+// across the whole of samples.
+func averageSpectrum(samples []int16) []float64 {
+	win := hann(specFFT)
+	frames := max(1, min(spectrumFrames, len(samples)/(specFFT/2)))
+	power := make([]float64, specFFT/2+1)
+	for f := range frames {
+		start := f * max(len(samples)-specFFT, 0) / max(frames-1, 1)
+		for k, p := range powerSpectrum(samples, start, win) {
+			power[k] += p / float64(frames)
+		}
+	}
+	return power
+}
+
+// spectrumBands folds power into width log-spaced bands from logFloor to
+// Nyquist, in dB. Bands narrower than a bin read between the two nearest.
+func spectrumBands(power []float64, width int) []float64 {
+	nyq := float64(specRate) / 2
+	binHz := float64(specRate) / specFFT
+	freq := func(x float64) float64 { return logFloor * math.Pow(nyq/logFloor, x/float64(width)) }
+	bands := make([]float64, width)
+	for x := range width {
+		lo, hi := freq(float64(x))/binHz, freq(float64(x+1))/binHz
+		var p float64
+		if int(hi)-int(lo) < 2 {
+			c := (lo + hi) / 2
+			k := min(int(c), len(power)-2)
+			f := c - float64(k)
+			p = power[k]*(1-f) + power[k+1]*f
+		} else {
+			bins := power[int(lo):min(int(hi), len(power))]
+			for _, v := range bins {
+				p += v
+			}
+			p /= float64(len(bins))
+		}
+		bands[x] = 10 * math.Log10(p+1e-12)
+	}
+	return bands
+}
+
+// spectrumDB is the dB range -f shows.
+func spectrumDB() float64 {
+	if opts.dbRange > 0 {
+		return opts.dbRange
+	}
+	return spectrumRange
+}
+
+// spectrumLevels scales dB bands to 0..1 against the loudest of them.
+func spectrumLevels(bands []float64) []float64 {
+	rng := spectrumDB()
+	top := math.Inf(-1)
+	for _, b := range bands {
+		top = math.Max(top, b)
+	}
+	levels := make([]float64, len(bands))
+	for i, b := range bands {
+		levels[i] = min(max((b-top+rng)/rng, 0), 1)
+	}
+	return levels
+}
+
+// renderSpectrum draws the long-term average spectrum of path as a bar
+// graph: log frequency across, dB relative to the loudest band up, with a
+// dB scale on the left and frequency labels underneath.
+func renderSpectrum(path string, width, height int) string {
+	samples := pcm(path, specRate, true)
+	if samples == nil {
+		return "  [no audio data]"
+	}
+	const labelW = 4
+	barW := max(width-labelW, 1)
+	levels := spectrumLevels(spectrumBands(averageSpectrum(samples), barW))
+
+	var sb strings.Builder
+	if hdr := header(path, getInfo(path), -1); hdr != "" {
+		sb.WriteString(hdr + "\n")
+	}
+	for i, row := range barRows(levels, height) {
+		label := ""
+		switch i {
+		case 0:
+			label = "0"
+		case height - 1:
+			label = fmt.Sprintf("-%.0f", spectrumDB())
+		}
+		fmt.Fprintf(&sb, "%*s ", labelW-1, label)
+		sb.WriteString(string(row) + "\n")
+	}
+	sb.WriteString(strings.Repeat(" ", labelW) + string(freqRow(barW)))
+	return sb.String()
+}
+
+// freqRow is a line of ticks and labels for width log-spaced bands.
+func freqRow(width int) []rune {
+	row := []rune(strings.Repeat(" ", width))
+	nyq := float64(specRate) / 2
+	col := func(f float64) int {
+		return int(math.Log(f/logFloor) / math.Log(nyq/logFloor) * float64(width))
+	}
+	for _, f := range freqTicks {
+		if x := col(f); f >= logFloor && x >= 0 && x < width {
+			row[x] = '╵'
+		}
+	}
+	for _, f := range freqTicks {
+		s := []rune(fmtHz(f))
+		x := col(f)
+		if f < logFloor || x < 0 || x+len(s) > width {
+			continue
+		}
+		free := true
+		for i := max(x-1, 0); i < min(x+len(s)+1, width); i++ {
+			if row[i] != ' ' && row[i] != '╵' {
+				free = false
+			}
+		}
+		if free {
+			copy(row[x:], s)
+		}
+	}
+	return row
+}
+
+func fmtHz(f float64) string {
+	if f >= 1000 {
+		return fmt.Sprintf("%gk", f/1000)
+	}
+	return fmt.Sprintf("%g", f)
+}
+
+// spectrumSpark is a one-line average spectrum of width bands, for the
+// directory listing.
+func spectrumSpark(path string, width int) string {
+	samples := pcm(path, specRate, true)
+	if samples == nil {
+		return strings.Repeat(" ", width)
+	}
+	levels := spectrumLevels(spectrumBands(averageSpectrum(samples), width))
+	spark := make([]rune, width)
+	for i, l := range levels {
+		spark[i] = blocks[int(l*float64(len(blocks)-1))]
+	}
+	return string(spark)
+}
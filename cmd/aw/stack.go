@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// onsetLevel is the fraction of a file's peak that counts as its first
+// transient for -align.
+const onsetLevel = 0.1
+
+// onset is the index of the first sample above onsetLevel of the peak.
+func onset(samples []int16) int {
+	var mx int16
+	for _, v := range samples {
+		mx = max(mx, v, -max(v, -32767))
+	}
+	thresh := int16(float64(mx) * onsetLevel)
+	for i, v := range samples {
+		if v > thresh || v < -thresh {
+			return i
+		}
+	}
+	return 0
+}
+
+// renderStack draws several files as lanes on one time axis, each under its
+// own header line, so similar samples can be compared. With align the first
+// transients line up; with -norm shared (the default here) quiet files look
+// quiet next to loud ones.
+func renderStack(paths []string, width, height int, align bool) string {
+	lanes := make([][]int16, len(paths))
+	lead := 0
+	for i, p := range paths {
+		lanes[i] = decode(p)
+		if align {
+			lead = max(lead, onset(lanes[i]))
+		}
+	}
+	// pad every lane to a common start and length
+	span := 0
+	shift := make([]int, len(paths))
+	for i, samples := range lanes {
+		if align {
+			shift[i] = lead - onset(samples)
+		}
+		span = max(span, shift[i]+len(samples))
+	}
+	if span == 0 {
+		return "  [no audio data]"
+	}
+	spanDur := float64(span) / decodeRate
+	from, to, zoomed := window(spanDur, -1)
+	lo, hi := int(from*float64(span)), int(to*float64(span))
+
+	shareReference("", paths, width)
+	laneH := max(1, height/len(paths))
+	var sb strings.Builder
+	if zoomed {
+		sb.WriteString(fmt.Sprintf("  %s-%s\n", fmtDur(from*spanDur), fmtDur(to*spanDur)))
+	}
+	for i, p := range paths {
+		padded := make([]int16, span)
+		copy(padded[shift[i]:], lanes[i])
+		samples := padded[lo:hi]
+
+		if hdr := header(p, getInfo(p), -1); hdr != "" {
+			sb.WriteString(hdr + "\n")
+		}
+		var mx int16
+		for _, pk := range makePeaks(samples, width) {
+			mx = max(mx, pk.abs())
+		}
+		var colors []rgb
+		if opts.color != "" {
+			colors = bandColors(stackBands(p, shift[i], span, lo, hi), width)
+		}
+		rows := waveRows(samples, reference(mx), width, laneH)
+		for r, row := range rows {
+			if colors != nil {
+				writeColorRow(&sb, row, -1, colors)
+			} else {
+				writeRow(&sb, row, -1)
+			}
+			if i < len(paths)-1 || r < len(rows)-1 {
+				sb.WriteByte('\n')
+			}
+		}
+	}
+	if opts.grid {
+		sb.WriteString("\n" + string(rulerRow(0, spanDur, from, to, width)))
+	}
+	return sb.String()
+}
+
+// stackBands is what renderStack draws of path, shifted and cut the same
+// way, at bandRate for bandColors. shift, span, lo and hi count decodeRate
+// samples.
+func stackBands(path string, shift, span, lo, hi int) []int16 {
+	k := float64(bandRate) / decodeRate
+	at := func(n int) int { return int(float64(n) * k) }
+	padded := make([]int16, at(span))
+	copy(padded[min(at(shift), len(padded)):], pcm(path, bandRate, true))
+	return padded[at(lo):at(hi)]
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jeeruff/alf/internal/audio"
+)
+
+// stdin is the audio read from standard input when the path is "-": the
+// whole stream, the sox options that describe it and its format. Every
+// decode reads data again, natively or through sox, so all modes work as
+// they do on files.
+var stdin struct {
+	data []byte
+	args []string
+	raw  bool // headerless PCM described by -rate/-chans/-enc
+	info audioInfo
+}
+
+// rawFormat describes headerless PCM on stdin.
+type rawFormat struct {
+	rate, chans int
+	enc         string
+}
+
+// rawEncodings maps -enc names to sox encoding and bit size.
+var rawEncodings = map[string]struct {
+	enc  string
+	bits int
+}{
+	"u8":   {"unsigned-integer", 8},
+	"s8":   {"signed-integer", 8},
+	"s16":  {"signed-integer", 16},
+	"s24":  {"signed-integer", 24},
+	"s32":  {"signed-integer", 32},
+	"f32":  {"floating-point", 32},
+	"f64":  {"floating-point", 64},
+	"ulaw": {"u-law", 8},
+	"alaw": {"a-law", 8},
+}
+
+// soxArgs turns the raw format into sox input options; the encoding is
+// u8, s8, s16, s24, s32, f32, f64, ulaw or alaw with an optional le/be
+// suffix (little-endian when omitted).
+func (r rawFormat) soxArgs() ([]string, error) {
+	if r.rate <= 0 || r.chans <= 0 {
+		return nil, fmt.Errorf("raw input needs -rate and -chans")
+	}
+	name, endian := r.enc, "-L"
+	if s, ok := strings.CutSuffix(name, "be"); ok {
+		name, endian = s, "-B"
+	} else {
+		name = strings.TrimSuffix(name, "le")
+	}
+	e, ok := rawEncodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown -enc %q (want u8, s8, s16, s24, s32, f32, f64, ulaw or alaw, with le/be)", r.enc)
+	}
+	args := []string{"-t", "raw", "-r", strconv.Itoa(r.rate), "-c", strconv.Itoa(r.chans), "-e", e.enc}
+	if e.bits > 8 {
+		args = append(args, endian)
+	}
+	return append(args, "-b", strconv.Itoa(e.bits)), nil
+}
+
+// sniffLen is how many bytes sniffType needs to tell every type: an Ogg
+// page header and the start of its first packet, where OpusHead sits.
+const sniffLen = 36
+
+// sniffType guesses the sox file type of a stream from its first bytes.
+func sniffType(b []byte) string {
+	switch {
+	case len(b) >= 12 && (string(b[0:4]) == "RIFF" || string(b[0:4]) == "RF64") && string(b[8:12]) == "WAVE":
+		return "wav"
+	case len(b) >= 12 && string(b[0:4]) == "FORM" && string(b[8:12]) == "AIFF":
+		return "aiff"
+	case len(b) >= 12 && string(b[0:4]) == "FORM" && string(b[8:12]) == "AIFC":
+		return "aifc"
+	case len(b) >= 4 && string(b[0:4]) == "fLaC":
+		return "flac"
+	case len(b) >= 36 && string(b[0:4]) == "OggS" && bytes.Contains(b[28:36], []byte("OpusHead")):
+		return "opus"
+	case len(b) >= 4 && string(b[0:4]) == "OggS":
+		return "ogg"
+	case len(b) >= 4 && string(b[0:4]) == "wvpk":
+		return "wv"
+	case len(b) >= 4 && string(b[0:4]) == ".snd":
+		return "au"
+	case len(b) >= 3 && string(b[0:3]) == "ID3",
+		len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0:
+		return "mp3"
+	}
+	return ""
+}
+
+// streamArgs works out the sox options for a stream starting with head: raw
+// PCM when -rate or -chans is given, else typ, else whatever the header says.
+func streamArgs(head []byte, typ string, raw rawFormat) ([]string, error) {
+	if raw.rate > 0 || raw.chans > 0 {
+		return raw.soxArgs()
+	}
+	if typ == "" {
+		typ = sniffType(head)
+	}
+	if typ == "" {
+		return nil, fmt.Errorf("can't tell the format; use -type, or -rate/-chans/-enc for raw PCM")
+	}
+	return []string{"-t", typ}, nil
+}
+
+// readStdin slurps standard input and works out how to decode it.
+// Duration comes from the decoded stream since piped headers often carry
+// no length.
+func readStdin(typ string, raw rawFormat) error {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("no data on stdin")
+	}
+	stdin.data = data
+	if stdin.args, err = streamArgs(data, typ, raw); err != nil {
+		return err
+	}
+	stdin.raw = raw.rate > 0 || raw.chans > 0
+	if stdin.raw {
+		stdin.info = audioInfo{sr: strconv.Itoa(raw.rate), ch: strconv.Itoa(raw.chans), bits: stdin.args[len(stdin.args)-1]}
+	} else if d, err := audio.NewDecoder(bytes.NewReader(data)); err == nil {
+		stdin.info = formatInfo(d.Format())
+	} else {
+		cmd := exec.Command("sox", append(append([]string{"--i"}, stdin.args...), "-")...)
+		cmd.Stdin = bytes.NewReader(data)
+		out, _ := cmd.Output()
+		stdin.info = parseInfo(out)
+	}
+
+	samples := decode("-")
+	if len(samples) == 0 {
+		return fmt.Errorf("no audio decoded from stdin")
+	}
+	stdin.info.dur = float64(len(samples)) / decodeRate
+	return nil
+}
+
+// soxInput is the sox input argument for path, and the stream to feed it.
+func soxInput(path string) ([]string, io.Reader) {
+	if path == "-" {
+		return append(append([]string{}, stdin.args...), "-"), bytes.NewReader(stdin.data)
+	}
+	return []string{path}, nil
+}
+
+// displayName is the name shown for path.
+func displayName(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return filepath.Base(path)
+}
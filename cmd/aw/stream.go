@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeeruff/alf/internal/audio"
+)
+
+// Long files are expensive in two ways: decoding a whole one to draw a
+// sparkline takes memory for every sample, and decoding takes time. The
+// sparkline streams its columns in one pass instead, and -maxtime and
+// -maxmem put a budget on the decoding the other views need.
+
+// sparks memoizes sparkPeaks by path and width.
+var sparks = map[string][]peak{}
+
+// cut records the paths cutShort has reported.
+var cut = map[string]bool{}
+
+// sparkPeaks is path bucketed into width columns for a sparkline. They
+// come from the file's peak pyramid if alf-index has made one fine
+// enough, and a file that isn't decoded already is otherwise streamed, in
+// memory that depends only on the width; draw, if set, is shown the
+// columns so far as it goes. With -maxtime the stream stops at the
+// deadline and the columns it didn't reach stay empty. Band colors need
+// the samples, so -color and stdin always decode the whole file.
+func sparkPeaks(path string, width int, draw func([]peak)) []peak {
+	key := fmt.Sprintf("%s\x00%d", path, width)
+	if p, ok := sparks[key]; ok {
+		return p
+	}
+	var p []peak
+	pyr := pyramid(path)
+	if opts.color == "" && pyr != nil && pyr.Resolves(0, pyr.Frames, width) {
+		p = toPeaks(pyr.Columns(0, pyr.Frames, width))
+	} else if _, ok := decoded[pcmKey(path, decodeRate, true)]; ok || path == "-" || opts.color != "" {
+		p = makePeaks(decode(path), width)
+	} else {
+		p = streamPeaks(path, width, draw)
+	}
+	sparks[key] = p
+	return p
+}
+
+// streamPeaks decodes path at decodeRate into width columns without
+// holding the samples. A file whose header doesn't give the length gets
+// its duration from the count.
+func streamPeaks(path string, width int, draw func([]peak)) []peak {
+	d, b, err := audio.OpenStream(path, decodeRate, true)
+	if err != nil {
+		return nil
+	}
+	defer d.Close()
+	backends[path] = b.Name()
+	stop := deadline()
+	last := time.Now()
+	cols, n, done, err := audio.StreamPeaks(d, width, func(p *audio.Peaks) bool {
+		if draw != nil && time.Since(last) >= 100*time.Millisecond {
+			draw(toPeaks(p.Columns()))
+			last = time.Now()
+		}
+		return stop.IsZero() || time.Now().Before(stop)
+	})
+	if n == 0 {
+		return nil
+	}
+	info := probeInfo(path)
+	switch {
+	case err != nil:
+		// the columns decoded before the error
+	case !done:
+		cutShort(path, float64(n)/decodeRate, info.dur)
+	case info.dur <= 0:
+		info.dur = float64(n) / decodeRate
+		infos[path] = info
+	}
+	return toPeaks(cols)
+}
+
+// liveLine draws a sparkline in progress on the terminal, leaving the
+// cursor at its start for the next draw or the finished line; nil when
+// stdout isn't a terminal.
+func liveLine() func(string) {
+	if fi, err := os.Stdout.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return func(spark string) { fmt.Print(spark + "\r") }
+}
+
+// toPeaks converts columns from the audio package.
+func toPeaks(cols []audio.Peak) []peak {
+	p := make([]peak, len(cols))
+	for i, c := range cols {
+		p[i] = peak{min: c.Min, max: c.Max, rms: c.RMS()}
+	}
+	return p
+}
+
+// deadline is when a decode starting now has to stop under -maxtime, or
+// zero without one.
+func deadline() time.Time {
+	if opts.maxTime <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(opts.maxTime)
+}
+
+// cutShort says once per file that -maxtime stopped it at got seconds of
+// total.
+func cutShort(path string, got, total float64) {
+	if cut[path] {
+		return
+	}
+	cut[path] = true
+	of := ""
+	if total > 0 {
+		of = " of " + fmtDur(total)
+	}
+	fmt.Fprintf(os.Stderr, "aw: %s: -maxtime reached at %s%s\n", displayName(path), fmtDur(got), of)
+}
+
+// decodeStream reads d to the end, or up to the -maxtime deadline. A decode
+// cut short is padded with silence to the estimated length, so positions
+// and times still line up with the file. One that fails partway keeps
+// what it got.
+func decodeStream(path string, d audio.Decoder) []int16 {
+	f := d.Format()
+	stop := deadline()
+	if stop.IsZero() {
+		samples, _ := audio.ReadAll(d)
+		return samples
+	}
+	var samples []int16
+	buf := make([]int16, 16384*f.Channels)
+	for {
+		n, err := d.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err != nil {
+			return samples
+		}
+		if time.Now().After(stop) {
+			break
+		}
+	}
+	got := float64(len(samples)/f.Channels) / float64(f.Rate)
+	cutShort(path, got, f.Duration())
+	if total := f.Frames * int64(f.Channels); int64(len(samples)) < total {
+		samples = append(samples, make([]int16, total-int64(len(samples)))...)
+	}
+	return samples
+}
+
+// envelopes memoizes envelope by path, lane count and columns.
+var envelopes = map[string][][]int16{}
+
+// overBudget says whether decoding path at decodeRate into nch lanes would
+// take more than -maxmem. Only files whose header gives the length can be
+// told in advance.
+func overBudget(info audioInfo, nch int) bool {
+	need := int64(info.dur*decodeRate) * int64(nch) * 2
+	return opts.maxMem > 0 && need > opts.maxMem
+}
+
+// envelope is path decoded to fit -maxmem: each lane is a run of min, max
+// pairs, one per column of a width that fills the budget. That is all the
+// waveform and its zoom need, though not band colors or an exact RMS. A
+// budget too small for width columns gets width columns anyway, rather than
+// a blank waveform.
+func envelope(path string, nch, width int) [][]int16 {
+	cols := max(int(opts.maxMem/int64(4*nch)), width)
+	key := fmt.Sprintf("%s\x00%d\x00%d", path, nch, cols)
+	if e, ok := envelopes[key]; ok {
+		return e
+	}
+	var lanes [][]int16
+	if d, b, err := audio.OpenStream(path, decodeRate, nch == 1); err == nil {
+		backends[path] = b.Name()
+		lanes = envelopeStream(path, d, cols)
+		d.Close()
+	}
+	envelopes[key] = lanes
+	return lanes
+}
+
+// envelopeStream buckets each channel of d into cols columns of min, max
+// pairs.
+func envelopeStream(path string, d audio.Decoder, cols int) [][]int16 {
+	f := d.Format()
+	ch := f.Channels
+	acc := make([]*audio.Peaks, ch)
+	for c := range acc {
+		acc[c] = audio.NewPeaks(cols, f.Frames)
+	}
+	stop := deadline()
+	buf := make([]int16, 16384*ch)
+	lane := make([]int16, 16384)
+	done := true
+	for {
+		n, err := d.Read(buf)
+		for c, p := range acc {
+			for i := range n / ch {
+				lane[i] = buf[i*ch+c]
+			}
+			p.Add(lane[:n/ch])
+		}
+		if err != nil {
+			// what decoded before an error is kept
+			break
+		}
+		if !stop.IsZero() && time.Now().After(stop) {
+			cutShort(path, float64(acc[0].Count())/decodeRate, f.Duration())
+			done = false
+			break
+		}
+	}
+	if acc[0].Count() == 0 {
+		return nil
+	}
+	lanes := make([][]int16, ch)
+	for c, p := range acc {
+		// a stream cut short leaves the rest silent rather than stretched
+		cols := p.Columns()
+		if done {
+			cols = p.Finish()
+		}
+		for _, q := range cols {
+			lanes[c] = append(lanes[c], q.Min, q.Max)
+		}
+	}
+	return lanes
+}
+
+// parseBytes reads a size in bytes with an optional K, M or G suffix.
+func parseBytes(s string) (int64, error) {
+	num, mult := s, int64(1)
+	switch strings.ToUpper(s[max(len(s)-1, 0):]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		num = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q (want bytes, or a number with K, M or G)", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// shades are the block -vector densities, emptiest first.
+var shades = []rune(" ░▒▓█")
+
+// stereoStats sums up how the two channels relate.
+type stereoStats struct {
+	corr  float64 // phase correlation, -1 (inverted) to +1 (mono)
+	width float64 // side RMS over mid RMS: 0 for mono, 1 for as much side as mid
+	side  float64 // side level relative to mid, dB
+	mono  float64 // level change of the mono fold-down, dB
+}
+
+func measureStereo(l, r []int16) stereoStats {
+	var ll, rr, lr, mm, ss float64
+	for i := range min(len(l), len(r)) {
+		a, b := float64(l[i]), float64(r[i])
+		ll += a * a
+		rr += b * b
+		lr += a * b
+		m, s := (a+b)/2, (a-b)/2
+		mm += m * m
+		ss += s * s
+	}
+	var st stereoStats
+	if ll > 0 && rr > 0 {
+		st.corr = lr / math.Sqrt(ll*rr)
+	} else if ll == rr {
+		st.corr = 1
+	}
+	st.side = math.Inf(-1)
+	if mm > 0 {
+		st.width = math.Sqrt(ss / mm)
+		if ss > 0 {
+			st.side = 10 * math.Log10(ss/mm)
+		}
+	} else if ss > 0 {
+		st.width, st.side = math.Inf(1), math.Inf(1)
+	}
+	st.mono = math.Inf(-1)
+	if mm > 0 && ll+rr > 0 {
+		st.mono = 10 * math.Log10(mm/((ll+rr)/2))
+	}
+	return st
+}
+
+// renderVector draws a goniometer of the first two channels of path: mid
+// (L+R) up, side (L-R) across, so mono is a vertical line, a left-only
+// signal leans to the top left and out-of-phase material spreads sideways.
+// Below it are a phase correlation meter and the stereo width.
+func renderVector(path string, width, height int) string {
+	info := getInfo(path)
+	nch, _ := strconv.Atoi(info.ch)
+	chans := decodeChannels(path, max(nch, 1))
+	if len(chans) == 0 || len(chans[0]) == 0 {
+		return "  [no audio data]"
+	}
+	l, r := chans[0], chans[0]
+	if len(chans) > 1 {
+		r = chans[1]
+	}
+	dur := info.dur
+	if dur <= 0 {
+		dur = float64(len(l)) / decodeRate
+	}
+	if from, to, zoomed := window(dur, -1); zoomed {
+		n := len(l)
+		l, r = l[int(from*float64(n)):int(to*float64(n))], r[int(from*float64(n)):int(to*float64(n))]
+	}
+
+	// square on screen: cells are about twice as tall as wide
+	plotW := min(width, height*2)
+	pad := strings.Repeat(" ", (width-plotW)/2)
+	var sb strings.Builder
+	if hdr := header(path, info, -1); hdr != "" {
+		sb.WriteString(hdr + "\n")
+	}
+	rows := vectorRows(l, r, plotW, height)
+	for i, row := range rows {
+		if i == 0 && plotW >= 3 {
+			row[0], row[plotW-1] = 'L', 'R'
+		}
+		sb.WriteString(pad + string(row) + "\n")
+	}
+
+	st := measureStereo(l, r)
+	barW := max(width-20, 8)
+	sb.WriteString(fmt.Sprintf("corr  -1 %s +1  %+.2f\n", corrBar(st.corr, barW), st.corr))
+	if len(chans) < 2 {
+		sb.WriteString("mono: no stereo image")
+		return sb.String()
+	}
+	w := "inf"
+	if !math.IsInf(st.width, 1) {
+		w = fmt.Sprintf("%.2f", st.width)
+	}
+	sb.WriteString(fmt.Sprintf("width %s  side %s dB  mono %s dB", w, fmtDB(st.side), fmtDB(st.mono)))
+	return sb.String()
+}
+
+// vectorRows plots sample pairs as braille dots with -b, else as shaded
+// blocks by how many samples land in each cell.
+func vectorRows(l, r []int16, width, height int) [][]rune {
+	pw, ph := width, height
+	if opts.braille {
+		pw, ph = width*2, height*4
+	}
+	// scale so the loudest point just fits; -norm fs keeps full scale
+	ref := float64(math.MaxInt16)
+	if opts.norm != "fs" {
+		var mx float64
+		for i := range min(len(l), len(r)) {
+			a, b := float64(l[i]), float64(r[i])
+			mx = math.Max(mx, math.Max(math.Abs(a+b), math.Abs(a-b))/math.Sqrt2)
+		}
+		if mx > 0 {
+			ref = mx
+		}
+	}
+	counts := make([]int, pw*ph)
+	for i := range min(len(l), len(r)) {
+		a, b := float64(l[i]), float64(r[i])
+		x := (b - a) / math.Sqrt2 / ref // side, right is positive
+		y := (a + b) / math.Sqrt2 / ref // mid, up is positive
+		px := min(int((x+1)/2*float64(pw)), pw-1)
+		py := min(int((1-y)/2*float64(ph)), ph-1)
+		if px >= 0 && px < pw && py >= 0 && py < ph {
+			counts[py*pw+px]++
+		}
+	}
+
+	rows := make([][]rune, height)
+	for r := range rows {
+		rows[r] = make([]rune, width)
+	}
+	if opts.braille {
+		for y := range ph {
+			for x := range pw {
+				if counts[y*pw+x] > 0 {
+					rows[y/4][x/2] |= dotBits[y%4][x%2]
+				}
+			}
+		}
+		for _, row := range rows {
+			for i := range row {
+				row[i] += 0x2800
+			}
+		}
+		return rows
+	}
+	// log density, so sparse transients show next to a dense body
+	var top int
+	for _, c := range counts {
+		top = max(top, c)
+	}
+	for y := range ph {
+		for x := range pw {
+			c := counts[y*pw+x]
+			i := 0
+			if c > 0 {
+				i = 1 + int(math.Log1p(float64(c))/math.Log1p(float64(top))*float64(len(shades)-2)+0.5)
+				i = min(i, len(shades)-1)
+			}
+			rows[y][x] = shades[i]
+		}
+	}
+	return rows
+}
+
+// corrBar is a -1..+1 scale of width cells with a marker at corr.
+func corrBar(corr float64, width int) string {
+	bar := []rune("├" + strings.Repeat("─", width-2) + "┤")
+	bar[width/2] = '┼'
+	bar[min(max(int((corr+1)/2*float64(width-1)+0.5), 0), width-1)] = '●'
+	return string(bar)
+}
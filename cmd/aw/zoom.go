@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// timeSpec is a -start/-end value: a fraction of the file, or seconds.
+type timeSpec struct {
+	v    float64
+	secs bool
+	set  bool
+}
+
+// parseTimeSpec accepts a fraction ("0.25"), seconds ("12.5s", or any plain
+// number above 1) and clock times ("1:02.5", "1:02:03").
+func parseTimeSpec(s string) (timeSpec, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return timeSpec{}, nil
+	}
+	if strings.Contains(s, ":") {
+		var secs float64
+		for _, part := range strings.Split(s, ":") {
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return timeSpec{}, fmt.Errorf("bad time %q", s)
+			}
+			secs = secs*60 + v
+		}
+		return timeSpec{v: secs, secs: true, set: true}, nil
+	}
+	secs := strings.HasSuffix(s, "s")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "s"), 64)
+	if err != nil || v < 0 {
+		return timeSpec{}, fmt.Errorf("bad time %q", s)
+	}
+	return timeSpec{v: v, secs: secs || v > 1, set: true}, nil
+}
+
+// frac resolves t against a file of dur seconds; def if t is unset.
+func (t timeSpec) frac(dur, def float64) float64 {
+	switch {
+	case !t.set:
+		return def
+	case t.secs && dur <= 0:
+		return def
+	case t.secs:
+		return t.v / dur
+	}
+	return t.v
+}
+
+// window returns the zoom range as fractions of the file, and whether it is
+// narrower than the whole file. With a playhead (pos >= 0) the window keeps
+// its length but is centered on pos.
+func window(dur, pos float64) (from, to float64, zoomed bool) {
+	from = min(max(opts.start.frac(dur, 0), 0), 1)
+	to = min(max(opts.end.frac(dur, 1), 0), 1)
+	if to <= from || (from == 0 && to == 1) {
+		return 0, 1, false
+	}
+	if pos >= 0 {
+		span := to - from
+		from = min(max(pos-span/2, 0), 1-span)
+		to = from + span
+	}
+	return from, to, true
+}
+
+// overviewLine is a one-line sparkline of the whole file with the zoom window
+// lit, everything outside it dimmed and the playhead cell highlighted.
+func overviewLine(lanes [][]peak, width int, from, to, pos float64) string {
+	levels := make([]int16, width)
+	var mx int16
+	for _, peaks := range lanes {
+		for i, p := range peaks {
+			levels[i] = max(levels[i], p.abs())
+			mx = max(mx, levels[i])
+		}
+	}
+	if mx == 0 {
+		mx = 1
+	}
+	lo := int(from * float64(width))
+	hi := max(int(to*float64(width)+0.999), lo+1)
+	play := -1
+	if pos >= 0 {
+		play = min(int(pos*float64(width)), width-1)
+	}
+
+	var sb strings.Builder
+	last := ""
+	for i, l := range levels {
+		style := DIM
+		switch {
+		case i == play:
+			style = SEL
+		case i >= lo && i < hi:
+			style = BRIGHT
+		}
+		if style != last {
+			sb.WriteString(style)
+			last = style
+		}
+		sb.WriteRune(blocks[int(amp(float64(l), reference(mx))*float64(len(blocks)-1))])
+	}
+	sb.WriteString(RST)
+	return sb.String()
+}
@@ -8,7 +8,8 @@ LFCONF="${XDG_CONFIG_HOME:-$HOME/.config}/lf"
 install -Dm755 aw "$PREFIX/bin/aw"
 install -Dm755 alf "$PREFIX/bin/alf"
 install -Dm755 alf-scope "$LFCONF/alf-scope"
+install -Dm755 alf-clean "$LFCONF/alf-clean"
 install -Dm644 alf-rc "$LFCONF/alf-rc"
 
-echo "installed: aw, alf, alf-scope, alf-rc"
+echo "installed: aw, alf, alf-scope, alf-clean, alf-rc"
 echo "run: alf /path/to/samples"
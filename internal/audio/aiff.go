@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// aifcEncodings maps AIFF-C compression types to sample storage; the size
+// of integer types comes from the COMM sample size.
+var aifcEncodings = map[string]encoding{
+	"NONE": {bigEndian: true},
+	"twos": {bigEndian: true},
+	"sowt": {},
+	"raw ": {size: 1, unsigned: true},
+	"in24": {size: 3, bigEndian: true},
+	"in32": {size: 4, bigEndian: true},
+	"42ni": {size: 3},
+	"23ni": {size: 4},
+	"fl32": {size: 4, float: true, bigEndian: true},
+	"FL32": {size: 4, float: true, bigEndian: true},
+	"fl64": {size: 8, float: true, bigEndian: true},
+	"FL64": {size: 8, float: true, bigEndian: true},
+}
+
+// newAIFF walks the IFF chunks up to "SSND", which must come after "COMM".
+// The frame count is held to what SSND, and the file when its length is
+// known, actually hold.
+func newAIFF(r *bufio.Reader, left func() int64) (Decoder, error) {
+	var form [12]byte
+	if _, err := io.ReadFull(r, form[:]); err != nil {
+		return nil, ErrFormat
+	}
+	aifc := string(form[8:12]) == "AIFC"
+	var (
+		f       Format
+		enc     encoding
+		hasComm bool
+	)
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, ErrFormat
+		}
+		id, size := string(hdr[0:4]), int64(binary.BigEndian.Uint32(hdr[4:8]))
+		switch id {
+		case "COMM":
+			if size > maxHeaderChunk {
+				return nil, ErrFormat
+			}
+			body := make([]byte, size+size&1)
+			if _, err := io.ReadFull(r, body); err != nil || size < 18 {
+				return nil, ErrFormat
+			}
+			be := binary.BigEndian
+			f = Format{
+				Channels: int(be.Uint16(body[0:2])),
+				Frames:   int64(be.Uint32(body[2:6])),
+				Bits:     int(be.Uint16(body[6:8])),
+				Rate:     int(math.Round(extended(body[8:18]))),
+			}
+			comp := "NONE"
+			if aifc && size >= 22 {
+				comp = string(body[18:22])
+			}
+			e, ok := aifcEncodings[comp]
+			if !ok || f.Channels < 1 || f.Rate < 1 {
+				return nil, ErrFormat
+			}
+			if e.size == 0 {
+				e.size = (f.Bits + 7) / 8
+			}
+			if e.size < 1 || e.size > 4 && !e.float {
+				return nil, ErrFormat
+			}
+			if e.float {
+				f.Float, f.Bits = true, 8*e.size
+			}
+			enc, hasComm = e, true
+		case "SSND":
+			if !hasComm || size < 8 {
+				return nil, ErrFormat
+			}
+			var off [8]byte
+			if _, err := io.ReadFull(r, off[:]); err != nil {
+				return nil, ErrFormat
+			}
+			skip := int64(binary.BigEndian.Uint32(off[0:4]))
+			if _, err := r.Discard(int(skip)); err != nil {
+				return nil, ErrFormat
+			}
+			frame := int64(enc.size * f.Channels)
+			n := min(size-8-skip, f.Frames*frame)
+			if l := left(); l >= 0 {
+				n = min(n, l)
+			}
+			f.Frames = max(n, 0) / frame
+			return &pcmDecoder{r: io.LimitReader(r, n), f: f, enc: enc}, nil
+		default:
+			if _, err := r.Discard(int(size + size&1)); err != nil {
+				return nil, ErrFormat
+			}
+		}
+	}
+}
+
+// extended decodes an 80-bit IEEE 754 extended float, the AIFF sample rate.
+func extended(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b[0:2]))
+	mant := binary.BigEndian.Uint64(b[2:10])
+	sign := 1.0
+	if exp&0x8000 != 0 {
+		sign, exp = -1, exp&0x7fff
+	}
+	if exp == 0 && mant == 0 {
+		return 0
+	}
+	return sign * math.Ldexp(float64(mant), exp-16383-63)
+}
@@ -0,0 +1,118 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"slices"
+	"testing"
+)
+
+// extendedBytes encodes a whole number as an 80-bit extended float.
+func extendedBytes(v int) []byte {
+	if v == 0 {
+		return make([]byte, 10)
+	}
+	exp := 63 - bits.LeadingZeros64(uint64(v))
+	b := binary.BigEndian.AppendUint16(nil, uint16(16383+exp))
+	return binary.BigEndian.AppendUint64(b, uint64(v)<<(63-exp))
+}
+
+// comm is a COMM chunk body; AIFF-C ones end in a compression type.
+func comm(ch int, frames, bits, rate int, comp string) []byte {
+	be := binary.BigEndian
+	b := be.AppendUint16(nil, uint16(ch))
+	b = be.AppendUint32(b, uint32(frames))
+	b = be.AppendUint16(b, uint16(bits))
+	b = append(b, extendedBytes(rate)...)
+	if comp != "" {
+		b = append(b, comp...)
+		b = append(b, 0, 0) // empty name
+	}
+	return b
+}
+
+func form(typ string, chunks ...[]byte) []byte {
+	body := []byte(typ)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return chunk(binary.BigEndian, "FORM", body)
+}
+
+func ssnd(data []byte) []byte {
+	return chunk(binary.BigEndian, "SSND", append(make([]byte, 8), data...))
+}
+
+func TestExtended(t *testing.T) {
+	for _, rate := range []int{8000, 22050, 44100, 48000, 96000, 192000} {
+		if got := extended(extendedBytes(rate)); got != float64(rate) {
+			t.Errorf("extended(%d) = %v", rate, got)
+		}
+	}
+}
+
+func TestAIFF(t *testing.T) {
+	be := binary.BigEndian
+	frames := len(testSamples) / 2
+	tests := []struct {
+		name  string
+		file  []byte
+		bits  int
+		float bool
+	}{
+		{"aiff 16", form("AIFF", chunk(be, "COMM", comm(2, frames, 16, 48000, "")), ssnd(encodeInts(testSamples, 2, true))), 16, false},
+		{"aiff 8", form("AIFF", chunk(be, "COMM", comm(2, frames, 8, 48000, "")), ssnd(encodeInts(testSamples, 1, true))), 8, false},
+		{"aiff 24", form("AIFF", chunk(be, "COMM", comm(2, frames, 24, 48000, "")), ssnd(encodeInts(testSamples, 3, true))), 24, false},
+		{"aifc sowt", form("AIFC", chunk(be, "COMM", comm(2, frames, 16, 48000, "sowt")), ssnd(encodeInts(testSamples, 2, false))), 16, false},
+		{"aifc fl32", form("AIFC", chunk(be, "COMM", comm(2, frames, 32, 48000, "fl32")), ssnd(encodeFloats(testSamples, true))), 32, true},
+		{"chunk before COMM", form("AIFF", chunk(be, "NAME", []byte("odd")), chunk(be, "COMM", comm(2, frames, 16, 48000, "")), ssnd(encodeInts(testSamples, 2, true))), 16, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, f := decodeBytes(t, tt.file)
+			want := Format{Rate: 48000, Channels: 2, Bits: tt.bits, Float: tt.float, Frames: int64(frames)}
+			if f != want {
+				t.Errorf("format %+v, want %+v", f, want)
+			}
+			if !slices.Equal(got, testSamples) {
+				t.Errorf("samples %v, want %v", got, testSamples)
+			}
+		})
+	}
+}
+
+func TestAIFFCut(t *testing.T) {
+	// COMM and SSND say five frames; the file stops after three
+	be := binary.BigEndian
+	file := form("AIFF", chunk(be, "COMM", comm(2, len(testSamples)/2, 16, 48000, "")), ssnd(encodeInts(testSamples, 2, true)))
+	got, f := decodeBytes(t, file[:len(file)-8])
+	if f.Frames != 3 || !slices.Equal(got, testSamples[:6]) {
+		t.Errorf("%d frames, samples %v; want 3, %v", f.Frames, got, testSamples[:6])
+	}
+}
+
+func TestAIFFRejects(t *testing.T) {
+	be := binary.BigEndian
+	huge := chunk(be, "COMM", nil)
+	be.PutUint32(huge[4:8], math.MaxInt32)
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"SSND before COMM", form("AIFF", ssnd(nil), chunk(be, "COMM", comm(2, 0, 16, 48000, "")))},
+		{"huge COMM", form("AIFF", huge)},
+		{"short COMM", form("AIFF", chunk(be, "COMM", make([]byte, 10)))},
+		{"unknown compression", form("AIFC", chunk(be, "COMM", comm(2, 0, 16, 48000, "ima4")))},
+		{"no rate", form("AIFF", chunk(be, "COMM", comm(2, 0, 16, 0, "")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDecoder(bytes.NewReader(tt.file)); !errors.Is(err, ErrFormat) {
+				t.Errorf("err %v, want ErrFormat", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Package audio decodes audio files for every alf command. WAV (PCM, IEEE
+// float and WAVE_FORMAT_EXTENSIBLE), RF64/BW64, AIFF/AIFF-C and FLAC are
+// read in-process, so previews and indexing don't need a process per file;
+// Probe and Decode hand anything else to sox and then ffmpeg.
+package audio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrFormat means the file is not in a format this package decodes.
+var ErrFormat = errors.New("audio: unsupported format")
+
+// maxHeaderChunk bounds the header chunks read whole: WAV fmt and ds64,
+// AIFF COMM. Real ones are well under it, and a size from a corrupt file
+// could otherwise ask for gigabytes.
+const maxHeaderChunk = 4 << 10
+
+// Format describes a decoded stream.
+type Format struct {
+	Rate     int
+	Channels int
+	Bits     int   // bits per sample in the file
+	Float    bool  // IEEE float samples
+	Frames   int64 // sample frames, -1 when the header doesn't say
+}
+
+// Duration is the length in seconds, or 0 when unknown.
+func (f Format) Duration() float64 {
+	if f.Frames < 0 || f.Rate <= 0 {
+		return 0
+	}
+	return float64(f.Frames) / float64(f.Rate)
+}
+
+// Decoder reads interleaved samples scaled to 16 bits.
+type Decoder interface {
+	Format() Format
+	// Read fills buf with whole frames and returns how many samples it
+	// wrote; io.EOF once the stream is exhausted.
+	Read(buf []int16) (int, error)
+	Close() error
+}
+
+// FloatDecoder is a Decoder that can also give samples at the file's own
+// precision, rather than cut to 16 bits. A stream is read with Read or
+// ReadFloat, not both.
+type FloatDecoder interface {
+	Decoder
+	// ReadFloat is Read with samples scaled to -1..1.
+	ReadFloat(buf []float32) (int, error)
+}
+
+// Open opens path and reads its header.
+func Open(path string) (Decoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDecoder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileDecoder{Decoder: d, f: f}, nil
+}
+
+type fileDecoder struct {
+	Decoder
+	f *os.File
+}
+
+func (d *fileDecoder) Close() error { return d.f.Close() }
+
+// NewDecoder reads a header from r and returns a decoder for the samples
+// after it. r is only read forward, so it may be a pipe. When r can say how
+// long it is, the length in the header is held to what r actually has.
+func NewDecoder(r io.Reader) (Decoder, error) {
+	size := streamSize(r)
+	c := &counter{r: r}
+	br := bufio.NewReaderSize(c, 64<<10)
+	// left is how much of r comes after what the header parser has taken
+	left := func() int64 {
+		if size < 0 {
+			return -1
+		}
+		return max(size-c.n+int64(br.Buffered()), 0)
+	}
+	magic, err := br.Peek(12)
+	if err != nil {
+		return nil, ErrFormat
+	}
+	// some taggers put an ID3v2 tag in front of FLAC
+	if string(magic[0:3]) == "ID3" {
+		size := 10 + (int(magic[6])<<21 | int(magic[7])<<14 | int(magic[8])<<7 | int(magic[9]))
+		if magic[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if _, err := br.Discard(size); err != nil {
+			return nil, ErrFormat
+		}
+		if magic, err = br.Peek(12); err != nil || string(magic[0:4]) != "fLaC" {
+			return nil, ErrFormat
+		}
+	}
+	switch id, form := string(magic[0:4]), string(magic[8:12]); {
+	case id == "fLaC":
+		return newFLAC(br, left)
+	case (id == "RIFF" || id == "RF64" || id == "BW64") && form == "WAVE":
+		return newWAV(br, left)
+	case id == "FORM" && (form == "AIFF" || form == "AIFC"):
+		return newAIFF(br, left)
+	}
+	return nil, ErrFormat
+}
+
+// streamSize is how many bytes r holds from where it is to its end: a
+// regular file's size less its offset, or what is unread of a reader in
+// memory. -1 for pipes and anything else that can't say.
+func streamSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case *os.File:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return -1
+		}
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return fi.Size() - pos
+	case interface{ Len() int }:
+		return int64(r.Len())
+	}
+	return -1
+}
+
+// counter counts the bytes read through it.
+type counter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Info reads only the header of path.
+func Info(path string) (Format, error) {
+	d, err := Open(path)
+	if err != nil {
+		return Format{}, err
+	}
+	defer d.Close()
+	return d.Format(), nil
+}
+
+// ReadAll decodes everything left in d.
+func ReadAll(d Decoder) ([]int16, error) {
+	f := d.Format()
+	var out []int16
+	if f.Frames > 0 {
+		out = make([]int16, 0, f.Frames*int64(f.Channels))
+	}
+	buf := make([]int16, 16384*f.Channels)
+	for {
+		n, err := d.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+}
+
+// DecodeFloat decodes path in-process at its own rate and precision, mixed
+// down to one channel when mono is set. Only the formats this package
+// reads itself can be; anything else is ErrFormat.
+func DecodeFloat(path string, mono bool) ([]float32, Format, error) {
+	d, err := Open(path)
+	if err != nil {
+		return nil, Format{}, err
+	}
+	defer d.Close()
+	f := d.Format()
+	fd, ok := d.(*fileDecoder).Decoder.(FloatDecoder)
+	if !ok {
+		return nil, f, ErrFormat
+	}
+	var out []float32
+	if f.Frames > 0 && mono {
+		out = make([]float32, 0, f.Frames)
+	} else if f.Frames > 0 {
+		out = make([]float32, 0, f.Frames*int64(f.Channels))
+	}
+	buf := make([]float32, 16384*f.Channels)
+	for {
+		n, err := fd.ReadFloat(buf)
+		if !mono || f.Channels == 1 {
+			out = append(out, buf[:n]...)
+		} else {
+			for i := 0; i+f.Channels <= n; i += f.Channels {
+				var sum float64
+				for _, v := range buf[i : i+f.Channels] {
+					sum += float64(v)
+				}
+				out = append(out, float32(sum/float64(f.Channels)))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, f, fmt.Errorf("audio: %w", err)
+		}
+	}
+	if mono {
+		f.Channels = 1
+	}
+	return out, f, nil
+}
+
+// Convert decodes the rest of d at rate, optionally mixed down to mono.
+func Convert(d Decoder, rate int, mono bool) ([]int16, Format, error) {
+	f := d.Format()
+	samples, err := ReadAll(NewConverter(d, rate, mono))
+	if err != nil {
+		return nil, f, fmt.Errorf("audio: %w", err)
+	}
+	return samples, f, nil
+}
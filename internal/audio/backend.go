@@ -0,0 +1,290 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Backend is one way of decoding a file.
+type Backend interface {
+	Name() string
+	// Probe reads the format without decoding.
+	Probe(path string) (Format, error)
+	// Stream starts decoding to 16-bit samples at rate, mixed to mono if
+	// asked. The stream's Format is the output's, with Frames estimated
+	// from the file's header, or -1.
+	Stream(path string, rate int, mono bool) (Decoder, error)
+}
+
+// The backends, in the order Probe and OpenStream try them: the in-process
+// decoder, then sox, then ffmpeg for whatever sox can't read, such as
+// m4a/alac, wma, ape and the audio tracks of video containers.
+var (
+	Native Backend = native{}
+	Sox    Backend = soxBackend{}
+	FFmpeg Backend = ffmpegBackend{}
+
+	Backends = []Backend{Native, Sox, FFmpeg}
+)
+
+// Probe returns the format of path from the first backend that can read
+// it, and which backend that was.
+func Probe(path string) (Format, Backend, error) {
+	for _, b := range Backends {
+		if f, err := b.Probe(path); err == nil {
+			return f, b, nil
+		}
+	}
+	return Format{}, nil, fmt.Errorf("%s: %w", path, ErrFormat)
+}
+
+// OpenStream starts decoding path with the first backend that gets any
+// samples out of it, and says which backend that was. Backends that can
+// open a file but decode nothing don't count, so a sox without the right
+// handler falls through to ffmpeg instead of giving silence.
+func OpenStream(path string, rate int, mono bool) (Decoder, Backend, error) {
+	for _, b := range Backends {
+		d, err := b.Stream(path, rate, mono)
+		if err != nil {
+			continue
+		}
+		if p := prime(d); p != nil {
+			return p, b, nil
+		}
+		d.Close()
+	}
+	return nil, nil, fmt.Errorf("%s: %w", path, ErrFormat)
+}
+
+// Decode is OpenStream read to the end.
+func Decode(path string, rate int, mono bool) ([]int16, Backend, error) {
+	d, b, err := OpenStream(path, rate, mono)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer d.Close()
+	samples, err := ReadAll(d)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return samples, b, nil
+}
+
+// primed is a stream whose first chunk was read to see that it decodes.
+type primed struct {
+	Decoder
+	head []int16
+	err  error
+}
+
+// prime reads the first chunk of d, or returns nil if there is none.
+func prime(d Decoder) Decoder {
+	head := make([]int16, 4096*d.Format().Channels)
+	n, err := d.Read(head)
+	if n == 0 {
+		return nil
+	}
+	return &primed{Decoder: d, head: head[:n], err: err}
+}
+
+func (p *primed) Read(buf []int16) (int, error) {
+	if len(p.head) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		return p.Decoder.Read(buf)
+	}
+	ch := p.Format().Channels
+	n := min(len(buf)/ch*ch, len(p.head))
+	copy(buf, p.head[:n])
+	p.head = p.head[n:]
+	return n, nil
+}
+
+type native struct{}
+
+func (native) Name() string { return "native" }
+
+func (native) Probe(path string) (Format, error) { return Info(path) }
+
+func (native) Stream(path string, rate int, mono bool) (Decoder, error) {
+	d, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewConverter(d, rate, mono), nil
+}
+
+// procDecoder reads raw 16-bit PCM from a decoding process.
+type procDecoder struct {
+	*pcmDecoder
+	cmd *exec.Cmd
+}
+
+// startProc runs a decoding process and reads its raw output. src is the
+// probed file, which gives the channel count and the length estimate.
+func startProc(src Format, rate int, mono bool, name string, args ...string) (Decoder, error) {
+	f := Format{Rate: rate, Channels: src.Channels, Bits: 16, Frames: -1}
+	if mono {
+		f.Channels = 1
+	}
+	if src.Frames >= 0 && src.Rate > 0 {
+		f.Frames = src.Frames * int64(rate) / int64(src.Rate)
+	}
+	cmd := exec.Command(name, args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &procDecoder{&pcmDecoder{r: out, f: f, enc: encoding{size: 2}}, cmd}, nil
+}
+
+// Close stops the process if it is still decoding.
+func (d *procDecoder) Close() error {
+	d.cmd.Process.Kill()
+	d.cmd.Wait()
+	return nil
+}
+
+type soxBackend struct{}
+
+func (soxBackend) Name() string { return "sox" }
+
+// Probe parses sox --i.
+func (soxBackend) Probe(path string) (Format, error) {
+	out, err := exec.Command("sox", "--i", path).Output()
+	if err != nil {
+		return Format{}, err
+	}
+	f := Format{Frames: -1}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.TrimSpace(key) {
+		case "Sample Rate":
+			f.Rate, _ = strconv.Atoi(val)
+		case "Channels":
+			f.Channels, _ = strconv.Atoi(val)
+		case "Precision":
+			f.Bits, _ = strconv.Atoi(strings.TrimSuffix(val, "-bit"))
+		case "Duration":
+			// "00:00:10.07 = 483456 samples ~ 755.4 CDDA sectors"
+			if _, rest, ok := strings.Cut(val, "= "); ok {
+				fmt.Sscan(rest, &f.Frames)
+			}
+		case "Sample Encoding":
+			f.Float = strings.Contains(val, "Floating")
+		}
+	}
+	if f.Rate <= 0 || f.Channels <= 0 {
+		return Format{}, ErrFormat
+	}
+	return f, nil
+}
+
+func (b soxBackend) Stream(path string, rate int, mono bool) (Decoder, error) {
+	src, err := b.Probe(path)
+	if err != nil {
+		return nil, err
+	}
+	// no dither, so decoding the same file twice gives the same samples
+	args := []string{"-D", path}
+	if mono {
+		args = append(args, "-c", "1")
+	}
+	args = append(args, "-r", strconv.Itoa(rate), "-b", "16", "-e", "signed-integer", "-t", "raw", "-")
+	return startProc(src, rate, mono, "sox", args...)
+}
+
+type ffmpegBackend struct{}
+
+func (ffmpegBackend) Name() string { return "ffmpeg" }
+
+// Probe asks ffprobe about the first audio stream.
+func (ffmpegBackend) Probe(path string) (Format, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=sample_rate,channels,sample_fmt,bits_per_raw_sample,bits_per_sample,duration:format=duration",
+		"-of", "default=noprint_wrappers=1", path).Output()
+	if err != nil {
+		return Format{}, err
+	}
+	f := Format{Frames: -1}
+	var dur float64
+	var sampleFmt string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || val == "N/A" {
+			continue
+		}
+		switch key {
+		case "sample_rate":
+			f.Rate, _ = strconv.Atoi(val)
+		case "channels":
+			f.Channels, _ = strconv.Atoi(val)
+		case "sample_fmt":
+			sampleFmt = val
+		case "bits_per_raw_sample", "bits_per_sample":
+			if n, _ := strconv.Atoi(val); n > 0 && f.Bits == 0 {
+				f.Bits = n
+			}
+		case "duration":
+			// the stream's comes first; containers like mkv only have the format's
+			if dur == 0 {
+				dur, _ = strconv.ParseFloat(val, 64)
+			}
+		}
+	}
+	if f.Rate <= 0 || f.Channels <= 0 {
+		return Format{}, ErrFormat
+	}
+	f.Float = strings.HasPrefix(sampleFmt, "flt") || strings.HasPrefix(sampleFmt, "dbl")
+	if f.Bits == 0 {
+		switch strings.TrimSuffix(sampleFmt, "p") {
+		case "u8":
+			f.Bits = 8
+		case "s16":
+			f.Bits = 16
+		case "s32", "flt":
+			f.Bits = 32
+		case "s64", "dbl":
+			f.Bits = 64
+		}
+	}
+	if dur > 0 {
+		f.Frames = int64(math.Round(dur * float64(f.Rate)))
+	}
+	return f, nil
+}
+
+// Stream decodes the first audio stream, so video containers work too.
+func (b ffmpegBackend) Stream(path string, rate int, mono bool) (Decoder, error) {
+	src, err := b.Probe(path)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-v", "error", "-nostdin", "-i", path, "-map", "0:a:0"}
+	if mono {
+		args = append(args, "-ac", "1")
+	}
+	args = append(args, "-ar", strconv.Itoa(rate), "-f", "s16le", "-")
+	return startProc(src, rate, mono, "ffmpeg", args...)
+}
+
+// Samples16 converts raw little-endian 16-bit PCM.
+func Samples16(raw []byte) []int16 {
+	samples := make([]int16, len(raw)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(raw[i*2:]))
+	}
+	return samples
+}
@@ -0,0 +1,305 @@
+package audio
+
+import (
+	"io"
+	"math"
+)
+
+// converter mixes down and resamples another decoder as it is read. Going
+// down it low-passes with a windowed sinc centered on each output frame,
+// so hats and air above the new Nyquist are cut rather than folded back
+// into the spectrum and band colors below it; going up it interpolates
+// linearly.
+type converter struct {
+	src  Decoder
+	f    Format
+	ch   int // source channels
+	mono bool
+	step float64 // source frames per output frame, 0 to keep the rate
+
+	in    []int16
+	frame []float64   // the source frame being pushed, after mixing
+	next  int64       // source frames seen
+	j     int64       // output frames made
+	lp    *lowpass    // anti-aliasing filter (down)
+	ready int64       // source frames output frame j waits for (down)
+	hist  [][]float64 // each channel from source frame hbase on, after mixing (down)
+	hbase int64
+	prev  []float64 // source frame next-2 (up)
+	cur   []float64 // source frame next-1 (up)
+	buf   []int16
+	out   []int16 // converted samples Read hasn't returned yet
+	eof   bool
+}
+
+// The anti-aliasing filter is -6dB at the new Nyquist and reaches
+// lowpassWidth output frames either side; its Kaiser window puts it 60dB
+// down by a fifth of the Nyquist past it. What folds back lands in the top
+// fifth of the new band, which the filter has already faded, and the rest
+// of the band stays clean.
+const (
+	lowpassWidth  = 9
+	lowpassBeta   = 5.65 // Kaiser β for 60dB
+	lowpassPhases = 256  // fractional positions the taps are worked out for
+)
+
+// lowpass is a windowed-sinc kernel tabulated at lowpassPhases fractional
+// offsets, each row of taps summing to one.
+type lowpass struct {
+	half int // taps before the center; there are 2*half+2 in all
+	taps [][]float64
+}
+
+func newLowpass(step float64) *lowpass {
+	fc := 0.5 / step // cycles per source frame
+	half := int(math.Ceil(lowpassWidth * step))
+	lp := &lowpass{half: half, taps: make([][]float64, lowpassPhases)}
+	for p := range lp.taps {
+		frac := float64(p) / lowpassPhases
+		row := make([]float64, 2*half+2)
+		var sum float64
+		for i := range row {
+			x := frac + float64(half-i) // distance from the center
+			w := 2 * fc
+			if x != 0 {
+				w = math.Sin(2*math.Pi*fc*x) / (math.Pi * x)
+			}
+			// the window reaches zero just past the last tap
+			r := x / float64(half+1)
+			w *= bessel0(lowpassBeta*math.Sqrt(max(1-r*r, 0))) / bessel0(lowpassBeta)
+			row[i] = w
+			sum += w
+		}
+		for i := range row {
+			row[i] /= sum
+		}
+		lp.taps[p] = row
+	}
+	return lp
+}
+
+// bessel0 is the modified Bessel function of the first kind, order zero,
+// by its power series.
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+// NewConverter decodes d at rate (its own rate if 0), mixed down to one
+// channel when mono is set. The result's Format has the new rate and
+// channel count, and Frames scaled to match.
+func NewConverter(d Decoder, rate int, mono bool) Decoder {
+	f := d.Format()
+	c := &converter{src: d, ch: f.Channels, mono: mono && f.Channels > 1}
+	c.f = f
+	if c.mono {
+		c.f.Channels = 1
+	}
+	if rate > 0 && f.Rate > 0 && rate != f.Rate {
+		c.step = float64(f.Rate) / float64(rate)
+		c.f.Rate = rate
+		if f.Frames >= 0 {
+			c.f.Frames = f.Frames * int64(rate) / int64(f.Rate)
+		}
+	}
+	if !c.mono && c.step == 0 {
+		return d
+	}
+	och := c.f.Channels
+	c.frame = make([]float64, och)
+	if c.step > 1 {
+		c.lp = newLowpass(c.step)
+		c.hist = make([][]float64, och)
+		c.ready = c.waitFor(0)
+	}
+	c.prev = make([]float64, och)
+	c.cur = make([]float64, och)
+	c.in = make([]int16, 4096*c.ch)
+	return c
+}
+
+func (c *converter) Format() Format { return c.f }
+
+func (c *converter) Close() error { return c.src.Close() }
+
+func (c *converter) Read(buf []int16) (int, error) {
+	for len(c.out) == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := min(len(buf)/c.f.Channels*c.f.Channels, len(c.out))
+	copy(buf, c.out[:n])
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// fill converts the next chunk of the source into out.
+func (c *converter) fill() error {
+	c.out = c.buf[:0]
+	n, err := c.src.Read(c.in)
+	for i := 0; i+c.ch <= n; i += c.ch {
+		c.push(c.in[i : i+c.ch])
+	}
+	switch err {
+	case nil:
+	case io.EOF:
+		c.eof = true
+		c.flush()
+	default:
+		return err
+	}
+	c.buf = c.out[:0]
+	return nil
+}
+
+// push takes one source frame.
+func (c *converter) push(frame []int16) {
+	if c.mono {
+		// integer average, as sox and the old whole-file mixdown did
+		var sum int
+		for _, v := range frame {
+			sum += int(v)
+		}
+		c.frame[0] = float64(sum / c.ch)
+	} else {
+		for k, v := range frame {
+			c.frame[k] = float64(v)
+		}
+	}
+	i := c.next
+	c.next++
+	switch {
+	case c.step == 0:
+		c.emit(c.frame)
+	case c.step > 1:
+		for k, v := range c.frame {
+			c.hist[k] = append(c.hist[k], v)
+		}
+		// output frames whose taps have all arrived
+		for c.next >= c.ready {
+			c.filter()
+		}
+		// drop what no output frame will look at again
+		if drop := c.ready - int64(len(c.lp.taps[0])) - c.hbase; drop > 8192 {
+			for k, h := range c.hist {
+				c.hist[k] = h[:copy(h, h[drop:])]
+			}
+			c.hbase += drop
+		}
+	default:
+		copy(c.prev, c.cur)
+		copy(c.cur, c.frame)
+		if i == 0 {
+			return
+		}
+		// output frames that fall between source frames i-1 and i
+		for int64(float64(c.j)*c.step) == i-1 {
+			c.interp(c.prev, c.cur, i-1)
+		}
+	}
+}
+
+// center is where output frame j sits in the source when going down: the
+// middle of the step frames it stands for, as a whole frame and a phase.
+func (c *converter) center(j int64) (int64, int) {
+	t := (float64(j)+0.5)*c.step - 0.5
+	base := math.Floor(t)
+	return int64(base), int((t - base) * lowpassPhases)
+}
+
+// waitFor is how many source frames output frame j needs, going down.
+func (c *converter) waitFor(j int64) int64 {
+	base, _ := c.center(j)
+	return base + int64(c.lp.half) + 2
+}
+
+// filter makes output frame j from the source frames around it. Past
+// either end of the stream the taps that are left are scaled back up to
+// unity, rather than pulling the level down towards silence.
+func (c *converter) filter() {
+	base, phase := c.center(c.j)
+	taps := c.lp.taps[phase]
+	first := base - int64(c.lp.half) // source frame under taps[0]
+	lo := max(c.hbase, first)
+	hi := min(c.next, first+int64(len(taps)))
+	norm := 1.0
+	if used := taps[lo-first : hi-first]; len(used) < len(taps) {
+		norm = 0
+		for _, t := range used {
+			norm += t
+		}
+		taps = used
+	}
+	for k, h := range c.hist {
+		y := dot(taps, h[lo-c.hbase:hi-c.hbase])
+		if norm != 0 {
+			y /= norm
+		}
+		c.frame[k] = y
+	}
+	c.emit(c.frame)
+	c.j++
+	c.ready = c.waitFor(c.j)
+}
+
+// dot is the dot product of a and the start of b, four products at a time.
+func dot(a, b []float64) float64 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float64
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// flush makes the output frames left at the end of the stream: going down,
+// one for every step frames the source had, as if it were bucketed; going
+// up, those after the last source frame.
+func (c *converter) flush() {
+	if c.step == 0 || c.next == 0 {
+		return
+	}
+	if c.step > 1 {
+		for int64(float64(c.j+1)*c.step) <= c.next {
+			c.filter()
+		}
+		return
+	}
+	frames := c.next
+	n := int64(float64(frames) / c.step)
+	for c.j < n {
+		c.interp(c.cur, c.cur, frames-1)
+	}
+}
+
+// interp makes output frame j between source frames i (a) and i+1 (b).
+func (c *converter) interp(a, b []float64, i int64) {
+	t := float64(c.j)*c.step - float64(i)
+	for k := range c.frame {
+		c.frame[k] = a[k] + (b[k]-a[k])*t
+	}
+	c.emit(c.frame)
+	c.j++
+}
+
+func (c *converter) emit(frame []float64) {
+	for _, v := range frame {
+		// the filter rings past full scale on clipped material
+		c.out = append(c.out, int16(min(max(math.Round(v), -32768), 32767)))
+	}
+}
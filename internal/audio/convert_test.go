@@ -0,0 +1,115 @@
+package audio
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// tone is a second of a sine at hz and amplitude amp, one channel or the
+// same on each of two.
+func tone(rate int, hz, amp float64, channels int) *sliceDecoder {
+	s := make([]int16, rate*channels)
+	for i := range rate {
+		v := int16(math.Round(amp * math.Sin(2*math.Pi*hz*float64(i)/float64(rate))))
+		for c := range channels {
+			s[i*channels+c] = v
+		}
+	}
+	return &sliceDecoder{f: Format{Rate: rate, Channels: channels, Bits: 16, Frames: int64(rate)}, s: s, chunk: 1000}
+}
+
+// gain is the level of samples against amp, in dB, leaving out the ends.
+func gain(samples []int16, amp float64) float64 {
+	mid := samples[len(samples)/10 : len(samples)*9/10]
+	var sum float64
+	for _, v := range mid {
+		sum += float64(v) * float64(v)
+	}
+	return 20 * math.Log10(math.Sqrt(sum/float64(len(mid)))/(amp/math.Sqrt2))
+}
+
+func TestConverterLowpass(t *testing.T) {
+	tests := []struct {
+		from     int
+		hz       float64
+		min, max float64 // dB
+	}{
+		{44100, 100, -0.05, 0.05},
+		{44100, 1000, -0.05, 0.05},
+		{44100, 3000, -0.1, 0.1},
+		{48000, 3000, -0.1, 0.1},
+		{96000, 2000, -0.1, 0.1},
+		// past the new Nyquist, folding back in band
+		{44100, 5000, -200, -60},
+		{44100, 6000, -200, -60},
+		{44100, 11025, -200, -60},
+		{48000, 7000, -200, -60},
+		{96000, 12000, -200, -60},
+		{44100, 15000, -200, -60},
+	}
+	for _, tt := range tests {
+		samples, f, err := Convert(tone(tt.from, tt.hz, 16000, 1), 8000, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != 8000 || f.Rate != tt.from {
+			t.Errorf("%d to 8000: %d samples", tt.from, len(samples))
+		}
+		if g := gain(samples, 16000); g < tt.min || g > tt.max {
+			t.Errorf("%d to 8000: %gHz at %.2fdB, want %g..%g", tt.from, tt.hz, g, tt.min, tt.max)
+		}
+	}
+}
+
+func TestConverterDC(t *testing.T) {
+	// a level holds to the very ends, where the filter runs out of samples
+	d := &sliceDecoder{f: Format{Rate: 44100, Channels: 2, Frames: 44100}, s: make([]int16, 2*44100)}
+	for i := range d.s {
+		d.s[i] = 10000
+		if i%2 == 1 {
+			d.s[i] = -4000
+		}
+	}
+	c := NewConverter(d, 8000, false)
+	if f := c.Format(); f.Rate != 8000 || f.Channels != 2 || f.Frames != 8000 {
+		t.Errorf("format %+v", f)
+	}
+	samples, err := ReadAll(c)
+	if err != nil || len(samples) != 2*8000 {
+		t.Fatalf("%d samples: %v", len(samples), err)
+	}
+	for i, v := range samples {
+		if want := []int16{10000, -4000}[i%2]; v != want {
+			t.Fatalf("sample %d: %d, want %d", i, v, want)
+		}
+	}
+}
+
+func TestConverterMono(t *testing.T) {
+	d := &sliceDecoder{f: Format{Rate: 8000, Channels: 2, Frames: 3}, s: []int16{100, 300, -7, 0, 32767, 32767}}
+	samples, err := ReadAll(NewConverter(d, 0, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the integer average, as sox mixes down
+	if want := []int16{200, -3, 32767}; !slices.Equal(samples, want) {
+		t.Errorf("mixdown %v, want %v", samples, want)
+	}
+	same := &sliceDecoder{f: Format{Rate: 8000, Channels: 1}}
+	if NewConverter(same, 8000, true) != Decoder(same) {
+		t.Errorf("a mono stream at its own rate is converted")
+	}
+}
+
+func TestConverterUp(t *testing.T) {
+	d := &sliceDecoder{f: Format{Rate: 4000, Channels: 1, Frames: 4}, s: []int16{0, 100, 300, -100}}
+	samples, err := ReadAll(NewConverter(d, 8000, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in between each source frame, then held after the last
+	if want := []int16{0, 50, 100, 200, 300, 100, -100, -100}; !slices.Equal(samples, want) {
+		t.Errorf("upsampled %v, want %v", samples, want)
+	}
+}
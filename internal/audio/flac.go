@@ -0,0 +1,439 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+var errFLAC = errors.New("audio: corrupt FLAC stream")
+
+// flacDecoder decodes a FLAC stream one frame at a time. Format and length
+// come from STREAMINFO; frames are not CRC-checked. A frame that doesn't
+// decode after others have ends the stream, so a truncated download or
+// an ID3v1 tag on the end still gives everything before it.
+type flacDecoder struct {
+	b      bitReader
+	f      Format
+	bps    uint // bits per sample from STREAMINFO
+	chans  [][]int64
+	buf    []int32
+	pend   []int32 // decoded samples Read hasn't returned yet, top-aligned
+	frames int     // frames decoded
+	eof    bool
+}
+
+// newFLAC reads the metadata blocks, which must start with STREAMINFO.
+// When the file's length is known and STREAMINFO gives the smallest frame,
+// the total is held to what that many bytes could hold, so a cut-off copy
+// doesn't claim the whole length.
+func newFLAC(r *bufio.Reader, left func() int64) (Decoder, error) {
+	if _, err := r.Discard(4); err != nil {
+		return nil, ErrFormat
+	}
+	d := &flacDecoder{b: bitReader{r: r}}
+	var maxBlock, minFrame int64
+	for first := true; ; first = false {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, ErrFormat
+		}
+		last, typ := hdr[0]&0x80 != 0, hdr[0]&0x7f
+		size := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+		if first != (typ == 0) {
+			return nil, ErrFormat
+		}
+		if typ == 0 {
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil || size < 34 {
+				return nil, ErrFormat
+			}
+			maxBlock = int64(binary.BigEndian.Uint16(body[2:4]))
+			minFrame = int64(body[4])<<16 | int64(body[5])<<8 | int64(body[6])
+			// rate:20 channels-1:3 bits-1:5 samples:36
+			u := binary.BigEndian.Uint64(body[10:18])
+			d.bps = uint(u>>36&31) + 1
+			d.f = Format{
+				Rate:     int(u >> 44),
+				Channels: int(u>>41&7) + 1,
+				Bits:     int(d.bps),
+				Frames:   int64(u & (1<<36 - 1)),
+			}
+			if d.f.Frames == 0 {
+				d.f.Frames = -1
+			}
+		} else if _, err := r.Discard(size); err != nil {
+			return nil, ErrFormat
+		}
+		if last {
+			break
+		}
+	}
+	if d.f.Rate == 0 {
+		return nil, ErrFormat
+	}
+	if n := left(); n >= 0 && minFrame > 0 && d.f.Frames > 0 {
+		d.f.Frames = min(d.f.Frames, n/minFrame*maxBlock)
+	}
+	d.chans = make([][]int64, d.f.Channels)
+	return d, nil
+}
+
+func (d *flacDecoder) Format() Format { return d.f }
+
+func (d *flacDecoder) Close() error { return nil }
+
+func (d *flacDecoder) Read(out []int16) (int, error) {
+	n, err := d.next(len(out))
+	for i, v := range d.pend[:n] {
+		out[i] = int16(v >> 16)
+	}
+	d.pend = d.pend[n:]
+	return n, err
+}
+
+func (d *flacDecoder) ReadFloat(out []float32) (int, error) {
+	n, err := d.next(len(out))
+	for i, v := range d.pend[:n] {
+		out[i] = float32(v) / (1 << 31)
+	}
+	d.pend = d.pend[n:]
+	return n, err
+}
+
+// next decodes frames until there are samples pending and says how many
+// whole frames' worth of them, up to n, to take.
+func (d *flacDecoder) next(n int) (int, error) {
+	for len(d.pend) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+		err := d.frame()
+		switch {
+		case err == nil:
+			d.frames++
+		case err == io.EOF, d.frames > 0 && (err == errFLAC || err == io.ErrUnexpectedEOF):
+			d.eof = true
+		default:
+			return 0, err
+		}
+	}
+	return min(n/d.f.Channels*d.f.Channels, len(d.pend)), nil
+}
+
+// Frame header block sizes and sample sizes by code; 0 means look
+// elsewhere (after the header or in STREAMINFO).
+var (
+	flacBlockSizes  = [16]int{0, 192, 576, 1152, 2304, 4608, 0, 0, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+	flacSampleSizes = [8]uint{0, 8, 12, 0, 16, 20, 24, 32}
+)
+
+// frame decodes the next frame into pend.
+func (d *flacDecoder) frame() error {
+	b := &d.b
+	if b.n == 0 {
+		b.fill()
+		if b.n == 0 {
+			return io.EOF
+		}
+	}
+	if b.read(15) != 0x3ffe<<1 {
+		return errFLAC
+	}
+	b.read(1) // blocking strategy
+	bsCode, rateCode := b.read(4), b.read(4)
+	chanCode, sizeCode := b.read(4), b.read(3)
+	b.read(1)
+	// frame or sample number, UTF-8 style
+	if c := b.read(8); c >= 0xc0 {
+		for range bits.LeadingZeros8(^uint8(c)) - 1 {
+			b.read(8)
+		}
+	}
+	size := flacBlockSizes[bsCode]
+	switch bsCode {
+	case 0:
+		return errFLAC
+	case 6:
+		size = int(b.read(8)) + 1
+	case 7:
+		size = int(b.read(16)) + 1
+	}
+	switch rateCode {
+	case 12:
+		b.read(8)
+	case 13, 14:
+		b.read(16)
+	case 15:
+		return errFLAC
+	}
+	bps := flacSampleSizes[sizeCode]
+	if sizeCode == 0 {
+		bps = d.bps
+	} else if bps == 0 {
+		return errFLAC
+	}
+	b.read(8) // CRC-8
+	nch := int(chanCode) + 1
+	if chanCode > 7 {
+		nch = 2
+	}
+	if chanCode > 10 || nch != d.f.Channels {
+		return errFLAC
+	}
+
+	for c := range nch {
+		if cap(d.chans[c]) < size {
+			d.chans[c] = make([]int64, size)
+		}
+		d.chans[c] = d.chans[c][:size]
+		sbps := bps
+		// the side channel needs one more bit
+		if chanCode == 8 && c == 1 || chanCode == 9 && c == 0 || chanCode == 10 && c == 1 {
+			sbps++
+		}
+		if err := d.subframe(d.chans[c], sbps); err != nil {
+			return err
+		}
+	}
+	b.align()
+	b.read(16) // CRC-16
+	if b.err != nil {
+		return errFLAC
+	}
+
+	if nch == 2 {
+		l, r := d.chans[0], d.chans[1]
+		for i := range size {
+			switch chanCode {
+			case 8: // left/side
+				r[i] = l[i] - r[i]
+			case 9: // side/right
+				l[i] += r[i]
+			case 10: // mid/side
+				mid := l[i]<<1 | r[i]&1
+				l[i], r[i] = (mid+r[i])>>1, (mid-r[i])>>1
+			}
+		}
+	}
+	if need := size * nch; cap(d.buf) < need {
+		d.buf = make([]int32, need)
+	}
+	d.pend = d.buf[:size*nch]
+	for c, s := range d.chans {
+		for i, v := range s {
+			d.pend[i*nch+c] = int32(v << (32 - bps))
+		}
+	}
+	return nil
+}
+
+// subframe decodes one channel of a frame into out.
+func (d *flacDecoder) subframe(out []int64, bps uint) error {
+	b := &d.b
+	if b.read(1) != 0 {
+		return errFLAC
+	}
+	typ := b.read(6)
+	var wasted uint
+	if b.read(1) == 1 {
+		wasted = uint(b.unary()) + 1
+		if wasted >= bps {
+			return errFLAC
+		}
+		bps -= wasted
+	}
+	switch {
+	case typ == 0: // constant
+		v := b.signed(bps)
+		for i := range out {
+			out[i] = v
+		}
+	case typ == 1: // verbatim
+		for i := range out {
+			out[i] = b.signed(bps)
+		}
+	case typ >= 8 && typ <= 12: // fixed predictor
+		order := int(typ - 8)
+		if order > len(out) {
+			return errFLAC
+		}
+		for i := range order {
+			out[i] = b.signed(bps)
+		}
+		if err := d.residual(out, order); err != nil {
+			return err
+		}
+		fixedPredict(out, order)
+	case typ >= 32: // LPC
+		order := int(typ-32) + 1
+		if order > len(out) {
+			return errFLAC
+		}
+		for i := range order {
+			out[i] = b.signed(bps)
+		}
+		prec := uint(b.read(4)) + 1
+		shift := b.signed(5)
+		if prec == 16 || shift < 0 {
+			return errFLAC
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			coefs[i] = b.signed(prec)
+		}
+		if err := d.residual(out, order); err != nil {
+			return err
+		}
+		for i := order; i < len(out); i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += c * out[i-1-j]
+			}
+			out[i] += sum >> shift
+		}
+	default:
+		return errFLAC
+	}
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return b.err
+}
+
+// residual reads the Rice-coded residual into out[order:].
+func (d *flacDecoder) residual(out []int64, order int) error {
+	b := &d.b
+	method := b.read(2)
+	if method > 1 {
+		return errFLAC
+	}
+	pbits, escape := uint(4), uint64(15)
+	if method == 1 {
+		pbits, escape = 5, 31
+	}
+	parts := 1 << b.read(4)
+	if len(out)%parts != 0 || len(out)/parts < order {
+		return errFLAC
+	}
+	i := order
+	for p := range parts {
+		end := (p + 1) * len(out) / parts
+		k := b.read(pbits)
+		if k == escape {
+			w := uint(b.read(5))
+			for ; i < end; i++ {
+				out[i] = b.signed(w)
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			u := b.unary()<<k | b.read(uint(k))
+			out[i] = int64(u>>1) ^ -int64(u&1)
+		}
+		if b.err != nil {
+			return errFLAC
+		}
+	}
+	return nil
+}
+
+// fixedPredict adds the fixed polynomial predictions of the given order to
+// the residuals in out.
+func fixedPredict(s []int64, order int) {
+	for i := order; i < len(s); i++ {
+		switch order {
+		case 1:
+			s[i] += s[i-1]
+		case 2:
+			s[i] += 2*s[i-1] - s[i-2]
+		case 3:
+			s[i] += 3*s[i-1] - 3*s[i-2] + s[i-3]
+		case 4:
+			s[i] += 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+		}
+	}
+}
+
+// bitReader reads big-endian bit fields. x holds n unread bits at the top.
+type bitReader struct {
+	r   *bufio.Reader
+	x   uint64
+	n   uint
+	err error
+}
+
+func (b *bitReader) fill() {
+	for b.n <= 56 {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if b.err == nil && err != io.EOF {
+				b.err = err
+			}
+			return
+		}
+		b.x |= uint64(c) << (56 - b.n)
+		b.n += 8
+	}
+}
+
+// read returns the next k (at most 57) bits.
+func (b *bitReader) read(k uint) uint64 {
+	if k == 0 {
+		return 0
+	}
+	if b.n < k {
+		b.fill()
+		if b.n < k {
+			b.err = io.ErrUnexpectedEOF
+			b.x, b.n = 0, 0
+			return 0
+		}
+	}
+	v := b.x >> (64 - k)
+	b.x <<= k
+	b.n -= k
+	return v
+}
+
+// signed reads k bits as a two's complement number.
+func (b *bitReader) signed(k uint) int64 {
+	if k == 0 {
+		return 0
+	}
+	return int64(b.read(k)<<(64-k)) >> (64 - k)
+}
+
+// unary counts zero bits up to the next one bit.
+func (b *bitReader) unary() uint64 {
+	var q uint64
+	for {
+		if b.n == 0 {
+			b.fill()
+			if b.n == 0 {
+				b.err = io.ErrUnexpectedEOF
+				return q
+			}
+		}
+		z := uint(bits.LeadingZeros64(b.x))
+		if z >= b.n {
+			q += uint64(b.n)
+			b.x, b.n = 0, 0
+			continue
+		}
+		b.x <<= z + 1
+		b.n -= z + 1
+		return q + uint64(z)
+	}
+}
+
+// align skips to the next byte boundary.
+func (b *bitReader) align() {
+	r := b.n % 8
+	b.x <<= r
+	b.n -= r
+}
@@ -0,0 +1,438 @@
+package audio
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"testing"
+)
+
+// bitWriter writes big-endian bit fields, the way FLAC packs them.
+type bitWriter struct {
+	b   []byte
+	cur byte
+	n   uint
+}
+
+func (w *bitWriter) write(v uint64, k uint) {
+	for i := k; i > 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>(i-1)&1)
+		if w.n++; w.n == 8 {
+			w.b = append(w.b, w.cur)
+			w.cur, w.n = 0, 0
+		}
+	}
+}
+
+func (w *bitWriter) signed(v int64, k uint) { w.write(uint64(v)&(1<<k-1), k) }
+
+func (w *bitWriter) unary(q uint64) {
+	for range q {
+		w.write(0, 1)
+	}
+	w.write(1, 1)
+}
+
+func (w *bitWriter) align() {
+	for w.n != 0 {
+		w.write(0, 1)
+	}
+}
+
+// flacSub is how a test frame codes its subframes.
+type flacSub int
+
+const (
+	subConstant flacSub = iota
+	subVerbatim
+	subFixed   // order 2, one Rice partition
+	subEscaped // order 1, two partitions, the second stored raw
+	subLPC     // order 2, as integer coefficients
+	subWasted  // verbatim, less the low bits every sample has clear
+)
+
+// flacFile encodes chans (one slice per channel, bps-bit samples) as a
+// FLAC stream in frames of block samples. Frames carry no CRCs, which the
+// decoder doesn't check.
+func flacFile(chans [][]int64, bps uint, block int, sub flacSub, stereo int) []byte {
+	w := &bitWriter{}
+	w.b = append(w.b, "fLaC"...)
+	// a padding block first would be invalid; one after STREAMINFO is skipped
+	w.b = append(w.b, 0, 0, 0, 34)
+	w.write(uint64(block), 16)
+	w.write(uint64(block), 16)
+	w.write(0, 24)
+	w.write(0, 24)
+	w.write(44100, 20)
+	w.write(uint64(len(chans)-1), 3)
+	w.write(uint64(bps-1), 5)
+	w.write(uint64(len(chans[0])), 36)
+	w.b = append(w.b, make([]byte, 16)...) // MD5
+	w.b = append(w.b, 0x81, 0, 0, 3, 0, 0, 0)
+	for num, start := 0, 0; start < len(chans[0]); num, start = num+1, start+block {
+		end := min(start+block, len(chans[0]))
+		flacFrame(w, chans, start, end, num, bps, sub, stereo)
+	}
+	return w.b
+}
+
+func flacFrame(w *bitWriter, chans [][]int64, start, end, num int, bps uint, sub flacSub, stereo int) {
+	n := end - start
+	w.write(0x3ffe, 14)
+	w.write(0, 2)
+	bsCode := map[int]uint64{192: 1, 4096: 12}[n]
+	switch {
+	case bsCode != 0:
+	case n <= 256:
+		bsCode = 6
+	default:
+		bsCode = 7
+	}
+	w.write(bsCode, 4)
+	w.write(0, 4) // rate from STREAMINFO
+	chanCode := uint64(len(chans) - 1)
+	if stereo != 0 {
+		chanCode = uint64(stereo)
+	}
+	w.write(chanCode, 4)
+	w.write(map[uint]uint64{8: 1, 12: 2, 16: 4, 20: 5, 24: 6}[bps], 3)
+	w.write(0, 1)
+	w.write(uint64(num), 8)
+	switch bsCode {
+	case 6:
+		w.write(uint64(n-1), 8)
+	case 7:
+		w.write(uint64(n-1), 16)
+	}
+	w.write(0, 8) // CRC-8
+	subs := make([][]int64, len(chans))
+	for c := range chans {
+		subs[c] = chans[c][start:end]
+	}
+	if stereo != 0 {
+		l, r := subs[0], subs[1]
+		side, mid := make([]int64, n), make([]int64, n)
+		for i := range n {
+			side[i], mid[i] = l[i]-r[i], (l[i]+r[i])>>1
+		}
+		subs = map[int][][]int64{8: {l, side}, 9: {side, r}, 10: {mid, side}}[stereo]
+	}
+	for c, s := range subs {
+		sbps := bps
+		if stereo == 8 && c == 1 || stereo == 9 && c == 0 || stereo == 10 && c == 1 {
+			sbps++
+		}
+		flacSubframe(w, s, sbps, sub)
+	}
+	w.align()
+	w.write(0, 16) // CRC-16
+}
+
+func flacSubframe(w *bitWriter, s []int64, bps uint, sub flacSub) {
+	w.write(0, 1)
+	switch sub {
+	case subConstant:
+		w.write(0, 6)
+		w.write(0, 1)
+		w.signed(s[0], bps)
+	case subVerbatim:
+		w.write(1, 6)
+		w.write(0, 1)
+		for _, v := range s {
+			w.signed(v, bps)
+		}
+	case subWasted:
+		w.write(1, 6)
+		w.write(1, 1)
+		w.unary(1) // two bits
+		for _, v := range s {
+			w.signed(v>>2, bps-2)
+		}
+	case subFixed, subEscaped:
+		order := 2
+		if sub == subEscaped {
+			order = 1
+		}
+		w.write(uint64(8+order), 6)
+		w.write(0, 1)
+		res := make([]int64, len(s))
+		for i := range s {
+			if i < order {
+				w.signed(s[i], bps)
+				continue
+			}
+			res[i] = s[i] - s[i-1]
+			if order == 2 {
+				res[i] = s[i] - 2*s[i-1] + s[i-2]
+			}
+		}
+		flacResidual(w, res, order, sub == subEscaped)
+	case subLPC:
+		w.write(32+1, 6) // order 2
+		w.write(0, 1)
+		w.signed(s[0], bps)
+		w.signed(s[1], bps)
+		// 7/4 s[i-1] - 3/4 s[i-2], in 4-bit coefficients shifted by 2
+		w.write(4-1, 4)
+		w.signed(2, 5)
+		w.signed(7, 4)
+		w.signed(-3, 4)
+		res := make([]int64, len(s))
+		for i := 2; i < len(s); i++ {
+			res[i] = s[i] - (7*s[i-1]-3*s[i-2])>>2
+		}
+		flacResidual(w, res, 2, false)
+	}
+}
+
+// flacResidual Rice-codes res[order:] in one partition with a 5-bit
+// parameter. Escaped, the last partition holds raw values instead, and the
+// first half gets a partition of its own when the block splits evenly.
+func flacResidual(w *bitWriter, res []int64, order int, escaped bool) {
+	w.write(1, 2)
+	if !escaped {
+		w.write(0, 4)
+		riceCode(w, res[order:])
+		return
+	}
+	half := order
+	if len(res)%2 == 0 {
+		w.write(1, 4)
+		half = len(res) / 2
+		riceCode(w, res[order:half])
+	} else {
+		w.write(0, 4)
+	}
+	width := uint(1)
+	for _, v := range res[half:] {
+		width = max(width, uint(bits.Len64(uint64(max(v, -v-1))))+1)
+	}
+	w.write(31, 5)
+	w.write(uint64(width), 5)
+	for _, v := range res[half:] {
+		w.signed(v, width)
+	}
+}
+
+// riceCode writes a partition's parameter and residuals, picking the
+// parameter from their mean size.
+func riceCode(w *bitWriter, res []int64) {
+	var sum uint64
+	for _, v := range res {
+		sum += uint64(max(v, -v))
+	}
+	k := uint(bits.Len64(sum / uint64(max(len(res), 1))))
+	w.write(uint64(k), 5)
+	for _, v := range res {
+		u := uint64(v<<1) ^ uint64(v>>63)
+		w.unary(u >> k)
+		w.write(u&(1<<k-1), k)
+	}
+}
+
+// flacSignal is a test signal: a few sines per channel, plus a click, at
+// just under full scale for bps bits and with the low bits clear where
+// wasted bits are to be tested.
+func flacSignal(channels, frames int, bps uint, sub flacSub) [][]int64 {
+	chans := make([][]int64, channels)
+	full := float64(int64(1)<<(bps-1) - 1)
+	for c := range chans {
+		chans[c] = make([]int64, frames)
+		for i := range chans[c] {
+			x := 0.5*math.Sin(float64(i)*0.05*float64(c+1)) + 0.3*math.Sin(float64(i)*0.31+float64(c))
+			if i == frames/3 {
+				x = -0.95
+			}
+			v := int64(math.Round(x * full))
+			switch sub {
+			case subConstant:
+				v = int64(c+1) * 100
+			case subWasted:
+				v &^= 3
+			}
+			chans[c][i] = v
+		}
+	}
+	return chans
+}
+
+func TestFLAC(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		bps      uint
+		block    int
+		sub      flacSub
+		stereo   int // channel assignment code, 0 for independent channels
+	}{
+		{"constant", 2, 16, 192, subConstant, 0},
+		{"verbatim mono", 1, 16, 192, subVerbatim, 0},
+		{"fixed", 2, 16, 192, subFixed, 0},
+		{"fixed left/side", 2, 16, 192, subFixed, 8},
+		{"fixed side/right", 2, 16, 192, subFixed, 9},
+		{"fixed mid/side", 2, 16, 192, subFixed, 10},
+		{"escaped partition", 2, 16, 4096, subEscaped, 0},
+		{"lpc", 2, 16, 192, subLPC, 10},
+		{"wasted bits", 2, 16, 192, subWasted, 0},
+		{"8-bit", 1, 8, 192, subFixed, 0},
+		{"12-bit", 2, 12, 192, subVerbatim, 0},
+		{"20-bit", 2, 20, 4096, subFixed, 0},
+		{"24-bit mid/side", 2, 24, 4096, subLPC, 10},
+		{"six channels", 6, 16, 192, subFixed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// not a whole number of blocks, so the last frame has its own size
+			frames := 5*tt.block + tt.block/2 + 77
+			chans := flacSignal(tt.channels, frames, tt.bps, tt.sub)
+			file := flacFile(chans, tt.bps, tt.block, tt.sub, tt.stereo)
+			got, f := decodeBytes(t, file)
+			want := Format{Rate: 44100, Channels: tt.channels, Bits: int(tt.bps), Frames: int64(frames)}
+			if f != want {
+				t.Errorf("format %+v, want %+v", f, want)
+			}
+			var wantSamples []int16
+			for i := range frames {
+				for c := range chans {
+					wantSamples = append(wantSamples, int16(int32(chans[c][i]<<(32-tt.bps))>>16))
+				}
+			}
+			if !slices.Equal(got, wantSamples) {
+				t.Errorf("samples differ: got %d, want %d; first %v, want %v", len(got), len(wantSamples), got[:min(len(got), 8)], wantSamples[:8])
+			}
+		})
+	}
+}
+
+func TestFLACFloat(t *testing.T) {
+	const bps = 24
+	chans := flacSignal(2, 1000, bps, subFixed)
+	d, err := NewDecoder(bytes.NewReader(flacFile(chans, bps, 192, subFixed, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []float32
+	buf := make([]float32, 512)
+	for {
+		n, err := d.(FloatDecoder).ReadFloat(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 2000 {
+		t.Fatalf("read %d samples, want 2000", len(got))
+	}
+	for i, v := range got {
+		// every bit of the 24 is kept
+		if want := float32(chans[i%2][i/2]) / (1 << (bps - 1)); v != want {
+			t.Fatalf("sample %d: %v, want %v", i, v, want)
+		}
+	}
+}
+
+func TestFLACID3(t *testing.T) {
+	chans := flacSignal(1, 300, 16, subVerbatim)
+	file := flacFile(chans, 16, 192, subVerbatim, 0)
+	tag := append([]byte("ID3\x04\x00\x00\x00\x00\x01\x00"), make([]byte, 128)...)
+	got, _ := decodeBytes(t, append(tag, file...))
+	if len(got) != 300 || int64(got[299]) != chans[0][299] {
+		t.Errorf("decoded %d samples behind an ID3 tag, want 300", len(got))
+	}
+}
+
+func TestFLACRejects(t *testing.T) {
+	file := flacFile(flacSignal(1, 300, 16, subVerbatim), 16, 192, subVerbatim, 0)
+	noInfo := slices.Concat([]byte("fLaC\x81\x00\x00\x03\x00\x00\x00"), file[4:])
+	if _, err := NewDecoder(bytes.NewReader(noInfo)); !errors.Is(err, ErrFormat) {
+		t.Errorf("padding before STREAMINFO: err %v, want ErrFormat", err)
+	}
+	// a frame that loses sync fails instead of decoding noise
+	bad := slices.Clone(file)
+	binary.BigEndian.PutUint16(bad[4+4+34+4+3:], 0x1234)
+	d, err := NewDecoder(bytes.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAll(d); err != errFLAC {
+		t.Errorf("lost sync: err %v, want errFLAC", err)
+	}
+}
+
+// rfcExample is decoding example 1 from RFC 9639 appendix D: one stereo
+// frame from another encoder, whose STREAMINFO MD5 checks the samples.
+const rfcExample = "664c614380000022100010000000" + "0f00000f0ac442f00000" +
+	"00013e84b41807dc69030758" + "6a3dad1a2e0ffff869180000" + "bf0358fd03128baa9a"
+
+func TestFLACReference(t *testing.T) {
+	file, err := hex.DecodeString(rfcExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, f := decodeBytes(t, file)
+	if want := []int16{25588, 10416}; !slices.Equal(got, want) {
+		t.Errorf("samples %v, want %v", got, want)
+	}
+	var le []byte
+	for _, v := range got {
+		le = binary.LittleEndian.AppendUint16(le, uint16(v))
+	}
+	if sum := md5.Sum(le); !bytes.Equal(sum[:], file[26:42]) || f.Channels != 2 || f.Bits != 16 {
+		t.Errorf("%+v: MD5 %x, STREAMINFO has %x", f, sum, file[26:42])
+	}
+}
+
+func TestFLACDamaged(t *testing.T) {
+	const block = 192
+	chans := flacSignal(2, 10*block, 16, subFixed)
+	file := flacFile(chans, 16, block, subFixed, 10)
+	var want []int16
+	for i := range chans[0] {
+		want = append(want, int16(chans[0][i]), int16(chans[1][i]))
+	}
+	// STREAMINFO gets the smallest frame, from what each adds to the file
+	size := func(k int) int {
+		return len(flacFile([][]int64{chans[0][:k*block], chans[1][:k*block]}, 16, block, subFixed, 10))
+	}
+	minFrame := len(file)
+	for k := range 10 {
+		minFrame = min(minFrame, size(k+1)-size(k))
+	}
+	file[12], file[13], file[14] = byte(minFrame>>16), byte(minFrame>>8), byte(minFrame)
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	tests := []struct {
+		name string
+		file []byte
+		all  bool // every frame is there
+	}{
+		{"ID3v1 tag", slices.Concat(file, id3v1), true},
+		{"junk", slices.Concat(file, []byte("\x00\x00garbage")), true},
+		{"cut mid-frame", file[:len(file)*2/3], false},
+		{"cut mid-frame, then a tag", slices.Concat(file[:len(file)*2/3], id3v1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, f := decodeBytes(t, tt.file)
+			if !tt.all && f.Frames >= int64(len(chans[0])) {
+				t.Errorf("%d frames, the whole length, in a cut-off stream", f.Frames)
+			}
+			switch {
+			case tt.all && len(got) != len(want):
+				t.Errorf("%d samples, want all %d", len(got), len(want))
+			case !tt.all && (len(got) == 0 || len(got) >= len(want) || len(got)%(2*block) != 0):
+				t.Errorf("%d samples, want the whole frames before the cut", len(got))
+			case !slices.Equal(got, want[:len(got)]):
+				t.Errorf("samples differ from the start of the stream")
+			}
+		})
+	}
+}
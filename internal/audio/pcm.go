@@ -0,0 +1,123 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// encoding is how one sample is stored.
+type encoding struct {
+	size      int // bytes per sample in the file
+	float     bool
+	bigEndian bool
+	unsigned  bool // 8-bit WAV and AIFC "raw " are offset binary
+}
+
+// pcmDecoder turns a run of interleaved frames into 16-bit samples.
+type pcmDecoder struct {
+	r   io.Reader
+	f   Format
+	enc encoding
+	buf []byte
+	eof bool
+}
+
+func (d *pcmDecoder) Format() Format { return d.f }
+
+func (d *pcmDecoder) Close() error { return nil }
+
+func (d *pcmDecoder) Read(out []int16) (int, error) {
+	n, err := d.fill(len(out))
+	if d.enc == (encoding{size: 2}) {
+		// the common case, and what the decoding processes write
+		for i := range n {
+			out[i] = int16(binary.LittleEndian.Uint16(d.buf[i*2:]))
+		}
+		return n, err
+	}
+	for i := range n {
+		out[i] = d.sample(d.buf[i*d.enc.size:])
+	}
+	return n, err
+}
+
+func (d *pcmDecoder) ReadFloat(out []float32) (int, error) {
+	n, err := d.fill(len(out))
+	for i := range n {
+		out[i] = d.sampleFloat(d.buf[i*d.enc.size:])
+	}
+	return n, err
+}
+
+// fill reads whole frames of up to n samples into buf and says how many
+// samples it read.
+func (d *pcmDecoder) fill(n int) (int, error) {
+	if d.eof {
+		return 0, io.EOF
+	}
+	frame := d.enc.size * d.f.Channels
+	frames := n / d.f.Channels
+	if need := frames * frame; len(d.buf) < need {
+		d.buf = make([]byte, need)
+	}
+	got, err := io.ReadFull(d.r, d.buf[:frames*frame])
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		d.eof, err = true, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	count := got / frame * d.f.Channels
+	if d.eof && count == 0 {
+		return 0, io.EOF
+	}
+	return count, nil
+}
+
+// sample converts the sample at the start of b.
+func (d *pcmDecoder) sample(b []byte) int16 {
+	if d.enc.float {
+		return int16(min(max(d.floatAt(b)*32768, -32768), 32767))
+	}
+	return int16(d.intAt(b) >> 16)
+}
+
+// sampleFloat converts the sample at the start of b at its own precision.
+func (d *pcmDecoder) sampleFloat(b []byte) float32 {
+	if d.enc.float {
+		return float32(d.floatAt(b))
+	}
+	return float32(d.intAt(b)) / (1 << 31)
+}
+
+// floatAt reads the float sample at the start of b.
+func (d *pcmDecoder) floatAt(b []byte) float64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if d.enc.bigEndian {
+		order = binary.BigEndian
+	}
+	if d.enc.size == 8 {
+		return math.Float64frombits(order.Uint64(b))
+	}
+	return float64(math.Float32frombits(order.Uint32(b)))
+}
+
+// intAt reads the integer sample at the start of b as the top bits of a
+// 32-bit int.
+func (d *pcmDecoder) intAt(b []byte) int32 {
+	e := d.enc
+	var u uint32
+	for i := range e.size {
+		k := i
+		if !e.bigEndian {
+			k = e.size - 1 - i
+		}
+		u = u<<8 | uint32(b[k])
+	}
+	u <<= 32 - 8*uint(e.size)
+	if e.unsigned {
+		u ^= 1 << 31
+	}
+	return int32(u)
+}
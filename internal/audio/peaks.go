@@ -0,0 +1,174 @@
+package audio
+
+import (
+	"io"
+	"math"
+)
+
+// Peak summarizes a run of samples.
+type Peak struct {
+	Min, Max int16
+	Sum2     float64 // sum of squares
+	N        int64
+}
+
+// Abs is the larger excursion from zero in either direction.
+func (p Peak) Abs() int16 {
+	if p.Min == math.MinInt16 {
+		return math.MaxInt16
+	}
+	return max(p.Max, -p.Min)
+}
+
+// RMS is the root mean square of the run.
+func (p Peak) RMS() float64 {
+	if p.N == 0 {
+		return 0
+	}
+	return math.Sqrt(p.Sum2 / float64(p.N))
+}
+
+func (p *Peak) merge(q Peak) {
+	p.Min, p.Max = min(p.Min, q.Min), max(p.Max, q.Max)
+	p.Sum2 += q.Sum2
+	p.N += q.N
+}
+
+// Peaks buckets a stream of samples into a fixed number of columns in one
+// pass, in memory that depends only on the width. Column i holds samples
+// i*total/width up to (i+1)*total/width, where total is the expected count:
+// given the exact count, the columns are what bucketing the whole decoded
+// file would give. A stream that runs past total doubles it, merging
+// columns in pairs; one that ends short is stretched by Finish.
+type Peaks struct {
+	cols  []Peak
+	total int64
+	n     int64
+	col   int
+	end   int64 // first sample past column col
+}
+
+// NewPeaks starts width columns for about total samples; total <= 0 means
+// the length is unknown. Fewer samples than columns leave gaps, as they
+// would bucketing the whole file.
+func NewPeaks(width int, total int64) *Peaks {
+	if total <= 0 {
+		total = int64(max(width, 1))
+	}
+	p := &Peaks{cols: make([]Peak, width), total: total}
+	p.seek()
+	return p
+}
+
+// seek finds the column of sample n and where it ends. The last column
+// takes up to a column's worth past total before the layout grows, so an
+// estimate a few samples short doesn't halve the resolution.
+func (p *Peaks) seek() {
+	w := int64(len(p.cols))
+	if w == 0 {
+		return
+	}
+	p.col = int(min(p.n*w/p.total, w-1))
+	for int64(p.col) < w-1 && int64(p.col+1)*p.total/w <= p.n {
+		p.col++
+	}
+	p.end = int64(p.col+1) * p.total / w
+	if int64(p.col) == w-1 {
+		p.end += p.total / w
+	}
+}
+
+// Add takes the next samples.
+func (p *Peaks) Add(samples []int16) {
+	if len(p.cols) == 0 {
+		p.n += int64(len(samples))
+		return
+	}
+	for len(samples) > 0 {
+		if p.n >= p.end {
+			p.grow()
+		}
+		// the run of samples that falls in the current column
+		k := min(int64(len(samples)), p.end-p.n)
+		c := &p.cols[p.col]
+		lo, hi, sum := c.Min, c.Max, c.Sum2
+		for _, v := range samples[:k] {
+			lo = min(lo, v)
+			hi = max(hi, v)
+			sum += float64(v) * float64(v)
+		}
+		c.Min, c.Max, c.Sum2 = lo, hi, sum
+		c.N += k
+		p.n += k
+		samples = samples[k:]
+		if p.n >= p.end && p.col < len(p.cols)-1 {
+			p.seek()
+		}
+	}
+}
+
+// grow doubles total. Column j of the new layout starts where column 2j
+// of the old one did, so merging pairs keeps the boundaries exact.
+func (p *Peaks) grow() {
+	w := len(p.cols)
+	for j := range w {
+		var m Peak
+		for _, i := range []int{2 * j, 2*j + 1} {
+			if i < w {
+				m.merge(p.cols[i])
+			}
+		}
+		p.cols[j] = m
+	}
+	p.total *= 2
+	p.seek()
+}
+
+// Count is how many samples have been added.
+func (p *Peaks) Count() int64 { return p.n }
+
+// Columns is the columns so far; those the stream hasn't reached are empty.
+func (p *Peaks) Columns() []Peak { return p.cols }
+
+// Finish is the columns once the stream has ended. If it ended short of
+// the expected length, the columns it reached are stretched over the width.
+func (p *Peaks) Finish() []Peak {
+	w := len(p.cols)
+	if p.n >= p.total || p.n == 0 {
+		return p.cols
+	}
+	used := p.col
+	if p.cols[p.col].N > 0 {
+		used++
+	}
+	out := make([]Peak, w)
+	for c := range out {
+		s := c * used / w
+		for _, q := range p.cols[s:max((c+1)*used/w, s+1)] {
+			out[c].merge(q)
+		}
+	}
+	return out
+}
+
+// StreamPeaks reads d into width columns of peaks and says how many
+// samples it read and whether it got to the end. After every chunk it
+// calls progress, if set, which can stop the stream by returning false;
+// the columns are then the partial ones.
+func StreamPeaks(d Decoder, width int, progress func(*Peaks) bool) ([]Peak, int64, bool, error) {
+	f := d.Format()
+	p := NewPeaks(width, f.Frames*int64(f.Channels))
+	buf := make([]int16, 16384*f.Channels)
+	for {
+		k, err := d.Read(buf)
+		p.Add(buf[:k])
+		switch {
+		case err == io.EOF:
+			return p.Finish(), p.Count(), true, nil
+		case err != nil:
+			return p.Columns(), p.Count(), false, err
+		case progress != nil && !progress(p):
+			return p.Columns(), p.Count(), false, nil
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package audio
+
+import (
+	"io"
+	"slices"
+	"testing"
+)
+
+// sliceDecoder decodes samples held in memory, chunk samples per Read.
+type sliceDecoder struct {
+	f     Format
+	s     []int16
+	chunk int
+}
+
+func (d *sliceDecoder) Format() Format { return d.f }
+
+func (d *sliceDecoder) Close() error { return nil }
+
+func (d *sliceDecoder) Read(buf []int16) (int, error) {
+	if len(d.s) == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(buf), len(d.s))
+	if d.chunk > 0 {
+		n = min(n, d.chunk)
+	}
+	n -= n % d.f.Channels
+	copy(buf, d.s[:n])
+	d.s = d.s[n:]
+	return n, nil
+}
+
+// ramp is n samples that go up and down, so every column has its own
+// extremes.
+func ramp(n int) []int16 {
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = int16((i*37)%200 - 100 + i)
+	}
+	return s
+}
+
+// columnsOf is what the whole of samples gives in width columns.
+func columnsOf(samples []int16, width int) []Peak {
+	cols := make([]Peak, width)
+	for c := range cols {
+		for _, v := range samples[c*len(samples)/width : (c+1)*len(samples)/width] {
+			cols[c].merge(Peak{Min: v, Max: v, Sum2: float64(v) * float64(v), N: 1})
+		}
+	}
+	return cols
+}
+
+func TestPeaksExact(t *testing.T) {
+	samples := ramp(1000)
+	for _, width := range []int{1, 7, 100, 333} {
+		for _, chunk := range []int{1, 13, 1000} {
+			p := NewPeaks(width, int64(len(samples)))
+			for s := samples; len(s) > 0; s = s[min(chunk, len(s)):] {
+				p.Add(s[:min(chunk, len(s))])
+			}
+			if got, want := p.Finish(), columnsOf(samples, width); !slices.Equal(got, want) {
+				t.Errorf("width %d in chunks of %d: columns differ from bucketing the whole", width, chunk)
+			}
+		}
+	}
+}
+
+func TestPeaksGrow(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int64
+		n       int
+		grown   bool // the layout doubles at least once
+		stretch bool // Finish spreads the columns reached over the width
+	}{
+		{"unknown length", 0, 1000, true, true},
+		{"long by a few samples", 1000, 1020, false, false},
+		{"twice as long", 1000, 2000, true, false},
+		{"half as long", 1000, 500, false, true},
+	}
+	const width = 10
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := ramp(tt.n)
+			p := NewPeaks(width, tt.total)
+			p.Add(samples)
+			if p.Count() != int64(tt.n) {
+				t.Errorf("Count %d, want %d", p.Count(), tt.n)
+			}
+			if grown := p.total > max(tt.total, width); grown != tt.grown {
+				t.Errorf("grew to %d for %d samples", p.total, tt.n)
+			}
+			cols := p.Finish()
+			var all Peak
+			for i, c := range cols {
+				if c.N == 0 {
+					t.Errorf("column %d empty", i)
+				}
+				all.merge(c)
+			}
+			// stretching repeats columns, so only the extremes add up
+			want := columnsOf(samples, 1)[0]
+			if all.Min != want.Min || all.Max != want.Max || !tt.stretch && all.N != want.N {
+				t.Errorf("columns hold %d samples in %d..%d, want %d in %d..%d", all.N, all.Min, all.Max, want.N, want.Min, want.Max)
+			}
+			if tt.stretch == slices.Equal(cols, p.Columns()) {
+				t.Errorf("Finish stretched the columns: %v, want %v", !tt.stretch, tt.stretch)
+			}
+		})
+	}
+}
+
+func TestPeaksFewSamples(t *testing.T) {
+	// three samples over ten columns leave gaps, as bucketing would
+	p := NewPeaks(10, 3)
+	p.Add([]int16{5, -5, 7})
+	if got, want := p.Finish(), columnsOf([]int16{5, -5, 7}, 10); !slices.Equal(got, want) {
+		t.Errorf("columns %v, want %v", got, want)
+	}
+}
+
+func TestStreamPeaks(t *testing.T) {
+	samples := ramp(4000)
+	tests := []struct {
+		name   string
+		frames int64
+	}{
+		{"known length", 2000},
+		{"unknown length", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &sliceDecoder{f: Format{Rate: 8000, Channels: 2, Frames: tt.frames}, s: samples, chunk: 300}
+			cols, n, done, err := StreamPeaks(d, 50, nil)
+			if err != nil || !done || n != int64(len(samples)) {
+				t.Fatalf("read %d samples, done %v: %v", n, done, err)
+			}
+			if tt.frames > 0 && !slices.Equal(cols, columnsOf(samples, 50)) {
+				t.Errorf("columns differ from bucketing the whole")
+			}
+			if len(cols) != 50 || cols[49].N == 0 {
+				t.Errorf("%d columns, the last with %d samples", len(cols), cols[len(cols)-1].N)
+			}
+		})
+	}
+	t.Run("stopped", func(t *testing.T) {
+		d := &sliceDecoder{f: Format{Rate: 8000, Channels: 1, Frames: 4000}, s: samples, chunk: 1000}
+		_, n, done, err := StreamPeaks(d, 50, func(p *Peaks) bool { return p.Count() < 2000 })
+		if err != nil || done || n != 2000 {
+			t.Errorf("read %d samples, done %v: %v; want 2000, not done", n, done, err)
+		}
+	})
+}
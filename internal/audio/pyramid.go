@@ -0,0 +1,271 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// PyramidRate is the rate pyramids are made at: the one aw, alf-list and
+// alf-meta decode at, so peaks drawn from a pyramid and those from decoding
+// have the same level and reference, and a zoom can go from one to the
+// other.
+const PyramidRate = 8000
+
+// PyramidSizes are the samples per bucket of each pyramid level, finest
+// first; each is a whole multiple of the one before.
+var PyramidSizes = []int{64, 256, 1024}
+
+var errStale = errors.New("audio: peak pyramid is out of date")
+
+// Pyramid is a file's peaks at several resolutions, mixed down to mono at
+// PyramidRate, so a waveform of any width or zoom range can be
+// drawn without decoding. It remembers the size and time of the file it
+// was made from, and reading it back fails once the file has changed.
+type Pyramid struct {
+	Rate   int
+	Frames int64
+	Levels [][]Peak // Levels[i] has one bucket per PyramidSizes[i] samples
+
+	size, modTime int64 // of the source file
+}
+
+// pyramidHeader starts a pyramid file; the levels follow as buckets.
+type pyramidHeader struct {
+	Magic   [8]byte
+	Size    int64
+	ModTime int64 // Unix nanoseconds
+	Rate    uint32
+	Levels  uint32
+	Frames  int64
+}
+
+// bucket is a Peak as stored.
+type bucket struct {
+	Min, Max int16
+	RMS      uint16
+}
+
+// bucketSize is the bytes a bucket takes in the file.
+const bucketSize = 6
+
+// levelLen is the buckets of size samples that frames fill, the last one
+// perhaps partly.
+func levelLen(frames int64, size int) int64 {
+	n := frames / int64(size)
+	if frames%int64(size) != 0 {
+		n++
+	}
+	return n
+}
+
+var pyramidMagic = [8]byte{'a', 'l', 'f', 'p', 'e', 'a', 'k', '2'}
+
+// BuildPyramid decodes path at PyramidRate, mixed down to mono, into a
+// pyramid, and says which backend decoded it.
+func BuildPyramid(path string) (*Pyramid, Backend, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, b, err := OpenStream(path, PyramidRate, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer d.Close()
+	p := &Pyramid{Rate: d.Format().Rate, size: fi.Size(), modTime: fi.ModTime().UnixNano()}
+	size := int64(PyramidSizes[0])
+	var cur Peak
+	var fine []Peak
+	buf := make([]int16, 16384)
+	for {
+		n, err := d.Read(buf)
+		for _, v := range buf[:n] {
+			cur.Min, cur.Max = min(cur.Min, v), max(cur.Max, v)
+			cur.Sum2 += float64(v) * float64(v)
+			if cur.N++; cur.N == size {
+				fine = append(fine, cur)
+				cur = Peak{}
+			}
+		}
+		p.Frames += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cur.N > 0 {
+		fine = append(fine, cur)
+	}
+	p.Levels = [][]Peak{fine}
+	for i := 1; i < len(PyramidSizes); i++ {
+		k := PyramidSizes[i] / PyramidSizes[i-1]
+		prev := p.Levels[i-1]
+		lv := make([]Peak, (len(prev)+k-1)/k)
+		for j, q := range prev {
+			lv[j/k].merge(q)
+		}
+		p.Levels = append(p.Levels, lv)
+	}
+	return p, b, nil
+}
+
+// Save writes the pyramid to name, creating its directory. The file is
+// written aside and renamed into place, so a reader never sees half of it.
+func (p *Pyramid) Save(name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".peaks-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	f.Chmod(0o644)
+	w := bufio.NewWriter(f)
+	hdr := pyramidHeader{pyramidMagic, p.size, p.modTime, uint32(p.Rate), uint32(len(p.Levels)), p.Frames}
+	binary.Write(w, binary.LittleEndian, hdr)
+	for _, lv := range p.Levels {
+		bs := make([]bucket, len(lv))
+		for j, q := range lv {
+			bs[j] = bucket{q.Min, q.Max, uint16(min(math.Round(q.RMS()), math.MaxUint16))}
+		}
+		binary.Write(w, binary.LittleEndian, uint32(len(bs)))
+		binary.Write(w, binary.LittleEndian, bs)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// readPyramidHeader opens name and reads its header, checking it against
+// the file at path.
+func readPyramidHeader(name, path string) (*bufio.Reader, *os.File, pyramidHeader, error) {
+	var hdr pyramidHeader
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, hdr, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, hdr, err
+	}
+	r := bufio.NewReader(f)
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil || hdr.Magic != pyramidMagic {
+		f.Close()
+		return nil, nil, hdr, ErrFormat
+	}
+	if hdr.Size != fi.Size() || hdr.ModTime != fi.ModTime().UnixNano() {
+		f.Close()
+		return nil, nil, hdr, errStale
+	}
+	return r, f, hdr, nil
+}
+
+// PyramidCurrent says whether name holds a pyramid of path as it is now,
+// reading only the header.
+func PyramidCurrent(name, path string) bool {
+	_, f, _, err := readPyramidHeader(name, path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// ReadPyramid reads the pyramid of path saved to name. It fails if there
+// is none or path has changed since.
+func ReadPyramid(name, path string) (*Pyramid, error) {
+	r, f, hdr, err := readPyramidHeader(name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if int(hdr.Levels) != len(PyramidSizes) || hdr.Rate == 0 || hdr.Frames < 0 {
+		return nil, ErrFormat
+	}
+	// the levels Frames makes for must fill the rest of the file exactly,
+	// before any of them is allocated
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	left := fi.Size() - int64(binary.Size(hdr))
+	for _, size := range PyramidSizes {
+		n := levelLen(hdr.Frames, size)
+		if left -= 4; n > left/bucketSize {
+			return nil, ErrFormat
+		}
+		left -= n * bucketSize
+	}
+	if left != 0 {
+		return nil, ErrFormat
+	}
+	p := &Pyramid{Rate: int(hdr.Rate), Frames: hdr.Frames, size: hdr.Size, modTime: hdr.ModTime}
+	for _, size := range PyramidSizes {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, ErrFormat
+		}
+		if int64(n) != levelLen(hdr.Frames, size) {
+			return nil, ErrFormat
+		}
+		bs := make([]bucket, n)
+		if err := binary.Read(r, binary.LittleEndian, bs); err != nil {
+			return nil, ErrFormat
+		}
+		lv := make([]Peak, n)
+		for j, b := range bs {
+			// the last bucket holds what is left over
+			cnt := min(int64(size), hdr.Frames-int64(j*size))
+			rms := float64(b.RMS)
+			lv[j] = Peak{Min: b.Min, Max: b.Max, Sum2: rms * rms * float64(cnt), N: cnt}
+		}
+		p.Levels = append(p.Levels, lv)
+	}
+	return p, nil
+}
+
+// Resolves says whether the pyramid has at least one bucket per column for
+// samples from..to drawn width columns wide. Closer zooms need the
+// samples themselves.
+func (p *Pyramid) Resolves(from, to int64, width int) bool {
+	return width > 0 && (to-from)/int64(PyramidSizes[0]) >= int64(width)
+}
+
+// Columns buckets samples from..to into width columns, from the coarsest
+// level that still has a few buckets per column. Column edges are rounded
+// to the nearest bucket edge.
+func (p *Pyramid) Columns(from, to int64, width int) []Peak {
+	n := to - from
+	lv := 0
+	for i := len(p.Levels) - 1; i > 0; i-- {
+		if n/int64(PyramidSizes[i]) >= 4*int64(width) {
+			lv = i
+			break
+		}
+	}
+	size, buckets := int64(PyramidSizes[lv]), p.Levels[lv]
+	cols := make([]Peak, width)
+	for c := range cols {
+		s := from + int64(c)*n/int64(width)
+		e := from + int64(c+1)*n/int64(width)
+		bs, be := (s+size/2)/size, (e+size/2)/size
+		be = max(be, bs+1)
+		for _, q := range buckets[min(bs, int64(len(buckets))):min(be, int64(len(buckets)))] {
+			cols[c].merge(q)
+		}
+	}
+	return cols
+}
@@ -0,0 +1,174 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeWAV writes 16-bit samples to a WAV file in dir.
+func writeWAV(t *testing.T, dir string, rate, channels int, samples []int16) string {
+	t.Helper()
+	le := binary.LittleEndian
+	file := riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, channels, rate, 2, 16, false)), chunk(le, "data", encodeInts(samples, 2, false)))
+	path := filepath.Join(dir, "a.wav")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPyramidRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     int
+		channels int
+		frames   int
+	}{
+		{"at the pyramid rate", PyramidRate, 1, 6400},
+		{"partial last buckets", PyramidRate, 1, 5000},
+		{"stereo 44.1kHz", 44100, 2, 44100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeWAV(t, dir, tt.rate, tt.channels, ramp(tt.frames*tt.channels))
+			p, b, err := BuildPyramid(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b != Native {
+				t.Errorf("built by %s, want native", b.Name())
+			}
+			want := int64(tt.frames) * PyramidRate / int64(tt.rate)
+			if p.Rate != PyramidRate || p.Frames != want {
+				t.Errorf("%d frames at %dHz, want %d at %d", p.Frames, p.Rate, want, PyramidRate)
+			}
+			name := filepath.Join(dir, "peaks", "a.wav.peaks")
+			if err := p.Save(name); err != nil {
+				t.Fatal(err)
+			}
+			if !PyramidCurrent(name, path) {
+				t.Errorf("PyramidCurrent is false for a pyramid just saved")
+			}
+			q, err := ReadPyramid(name, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Rate != p.Rate || q.Frames != p.Frames || len(q.Levels) != len(p.Levels) {
+				t.Fatalf("read back %d frames at %dHz in %d levels", q.Frames, q.Rate, len(q.Levels))
+			}
+			for i, lv := range p.Levels {
+				if len(q.Levels[i]) != len(lv) || int64(len(lv)) != levelLen(p.Frames, PyramidSizes[i]) {
+					t.Fatalf("level %d: %d buckets read back of %d", i, len(q.Levels[i]), len(lv))
+				}
+				for j, w := range lv {
+					g := q.Levels[i][j]
+					// RMS is stored to the nearest whole sample value
+					if g.Min != w.Min || g.Max != w.Max || g.N != w.N || math.Abs(g.RMS()-w.RMS()) > 0.5 {
+						t.Fatalf("level %d bucket %d: %+v, want %+v", i, j, g, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPyramidColumns(t *testing.T) {
+	// ten columns of 640 samples are ten level-0 buckets each
+	samples := ramp(6400)
+	path := writeWAV(t, t.TempDir(), PyramidRate, 1, samples)
+	p, _, err := BuildPyramid(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Resolves(0, p.Frames, 100) || p.Resolves(0, p.Frames, 101) {
+		t.Errorf("Resolves wants one level-0 bucket per column")
+	}
+	want := columnsOf(samples, 10)
+	for i, c := range p.Columns(0, p.Frames, 10) {
+		if c.Min != want[i].Min || c.Max != want[i].Max || c.N != want[i].N {
+			t.Errorf("column %d: %+v, want %+v", i, c, want[i])
+		}
+	}
+}
+
+func TestPyramidStale(t *testing.T) {
+	dir := t.TempDir()
+	path := writeWAV(t, dir, PyramidRate, 1, ramp(1000))
+	p, _, err := BuildPyramid(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "a.wav.peaks")
+	if err := p.Save(name); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if PyramidCurrent(name, path) {
+		t.Errorf("PyramidCurrent is true for a file touched since")
+	}
+	if _, err := ReadPyramid(name, path); !errors.Is(err, errStale) {
+		t.Errorf("ReadPyramid of a file touched since: err %v, want errStale", err)
+	}
+	if _, err := ReadPyramid(filepath.Join(dir, "none.peaks"), path); err == nil {
+		t.Errorf("ReadPyramid of no pyramid succeeded")
+	}
+}
+
+func TestPyramidCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := writeWAV(t, dir, PyramidRate, 1, ramp(1000))
+	p, _, err := BuildPyramid(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(dir, "good.peaks")
+	if err := p.Save(good); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	framesAt := binary.Size(pyramidHeader{}) - 8
+	tests := []struct {
+		name   string
+		change func(b []byte) []byte
+	}{
+		{"old magic", func(b []byte) []byte { b[7] = '1'; return b }},
+		{"huge frame count", func(b []byte) []byte {
+			binary.LittleEndian.PutUint64(b[framesAt:], 1<<62)
+			return b
+		}},
+		{"negative frame count", func(b []byte) []byte {
+			binary.LittleEndian.PutUint64(b[framesAt:], math.MaxUint64)
+			return b
+		}},
+		{"frame count off by a bucket", func(b []byte) []byte {
+			binary.LittleEndian.PutUint64(b[framesAt:], uint64(p.Frames+64))
+			return b
+		}},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"trailing bytes", func(b []byte) []byte { return append(b, 0) }},
+		{"header only", func(b []byte) []byte { return b[:framesAt+8] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, "bad.peaks")
+			if err := os.WriteFile(name, tt.change(append([]byte(nil), saved...)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadPyramid(name, path); !errors.Is(err, ErrFormat) {
+				t.Errorf("err %v, want ErrFormat", err)
+			}
+		})
+	}
+}
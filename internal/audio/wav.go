@@ -0,0 +1,128 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	wavePCM        = 1
+	waveFloat      = 3
+	waveExtensible = 0xfffe
+	unknownSize    = 0xffffffff // RF64 placeholder, also written by pipes
+	soxSize        = 0x7ffff000 // what sox puts in the data header on a pipe
+)
+
+// newWAV walks the RIFF chunks up to "data". RF64 and BW64 keep the real
+// data size in a ds64 chunk and 0xffffffff in the data header; a stream
+// that can't say how long it is gets read to EOF. A data size past the end
+// of a file whose length is known, from a placeholder or a cut-off copy, is
+// cut to what the file has left.
+func newWAV(r *bufio.Reader, left func() int64) (Decoder, error) {
+	if _, err := r.Discard(12); err != nil {
+		return nil, ErrFormat
+	}
+	var (
+		f      Format
+		enc    encoding
+		hasFmt bool
+		ds64   int64 = -1
+	)
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, ErrFormat
+		}
+		id, size := string(hdr[0:4]), int64(binary.LittleEndian.Uint32(hdr[4:8]))
+		switch id {
+		case "data":
+			if !hasFmt {
+				return nil, ErrFormat
+			}
+			switch {
+			case size == unknownSize && ds64 >= 0:
+				size = ds64
+			case size == unknownSize, size == soxSize:
+				size = -1
+			}
+			if n := left(); n >= 0 && (size < 0 || size > n) {
+				size = n
+			}
+			d := &pcmDecoder{r: r, f: f, enc: enc}
+			d.f.Frames = -1
+			if size >= 0 {
+				d.r = io.LimitReader(r, size)
+				d.f.Frames = size / int64(enc.size*f.Channels)
+			}
+			return d, nil
+		case "ds64", "fmt ":
+			if size > maxHeaderChunk {
+				return nil, ErrFormat
+			}
+			body := make([]byte, size+size&1)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, ErrFormat
+			}
+			if id == "ds64" {
+				if size >= 16 {
+					ds64 = int64(binary.LittleEndian.Uint64(body[8:16]))
+				}
+				continue
+			}
+			var err error
+			if f, enc, err = parseWAVFormat(body[:size]); err != nil {
+				return nil, err
+			}
+			hasFmt = true
+		default:
+			if _, err := r.Discard(int(size + size&1)); err != nil {
+				return nil, ErrFormat
+			}
+		}
+	}
+}
+
+// parseWAVFormat reads a fmt chunk. Extensible files carry the real format
+// tag in the first two bytes of their subformat GUID.
+func parseWAVFormat(b []byte) (Format, encoding, error) {
+	if len(b) < 16 {
+		return Format{}, encoding{}, ErrFormat
+	}
+	le := binary.LittleEndian
+	tag := le.Uint16(b[0:2])
+	f := Format{
+		Channels: int(le.Uint16(b[2:4])),
+		Rate:     int(le.Uint32(b[4:8])),
+		Bits:     int(le.Uint16(b[14:16])),
+	}
+	align := int(le.Uint16(b[12:14]))
+	if tag == waveExtensible && len(b) >= 26 {
+		if valid := int(le.Uint16(b[18:20])); valid > 0 {
+			f.Bits = valid
+		}
+		tag = le.Uint16(b[24:26])
+	}
+	if f.Channels < 1 || f.Rate < 1 {
+		return Format{}, encoding{}, ErrFormat
+	}
+	enc := encoding{size: (f.Bits + 7) / 8}
+	if align >= f.Channels && align%f.Channels == 0 {
+		enc.size = align / f.Channels
+	}
+	switch tag {
+	case wavePCM:
+		if enc.size < 1 || enc.size > 4 {
+			return Format{}, encoding{}, ErrFormat
+		}
+		enc.unsigned = enc.size == 1
+	case waveFloat:
+		if enc.size != 4 && enc.size != 8 {
+			return Format{}, encoding{}, ErrFormat
+		}
+		enc.float, f.Float = true, true
+	default:
+		return Format{}, encoding{}, ErrFormat
+	}
+	return f, enc, nil
+}
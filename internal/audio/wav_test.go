@@ -0,0 +1,245 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"testing"
+)
+
+// testSamples are stereo frames that survive every encoding the tests
+// write: multiples of 256 so 8-bit files hold them exactly.
+var testSamples = []int16{0, 0, 256, -256, 32512, -32768, -12800, 4608, 1024, -1024}
+
+// chunk is a RIFF or IFF chunk, padded to an even size.
+func chunk(order binary.AppendByteOrder, id string, body []byte) []byte {
+	b := append([]byte(id), order.AppendUint32(nil, uint32(len(body)))...)
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// wavFmt is a fmt chunk body; extensible ones carry tag in the subformat.
+func wavFmt(tag uint16, ch, rate, size, bits int, extensible bool) []byte {
+	le := binary.LittleEndian
+	outer := tag
+	if extensible {
+		outer = waveExtensible
+	}
+	b := le.AppendUint16(nil, outer)
+	b = le.AppendUint16(b, uint16(ch))
+	b = le.AppendUint32(b, uint32(rate))
+	b = le.AppendUint32(b, uint32(rate*ch*size))
+	b = le.AppendUint16(b, uint16(ch*size))
+	b = le.AppendUint16(b, uint16(8*size))
+	if extensible {
+		b = le.AppendUint16(b, 22)
+		b = le.AppendUint16(b, uint16(bits))
+		b = le.AppendUint32(b, 3) // channel mask
+		b = le.AppendUint16(b, tag)
+		b = append(b, 0, 0, 0, 0, 0x10, 0, 0x80, 0, 0, 0xaa, 0, 0x38, 0x9b, 0x71)
+	}
+	return b
+}
+
+// encodeInts stores samples as size-byte signed integers, the 16 bits at
+// the top and the bits below them set so a decoder has to drop them.
+func encodeInts(samples []int16, size int, bigEndian bool) []byte {
+	var b []byte
+	for _, v := range samples {
+		u := uint32(uint16(v)) << 16
+		if size > 2 {
+			u |= 0x7f << 8
+		}
+		be := binary.BigEndian.AppendUint32(nil, u)[:size]
+		if !bigEndian {
+			slices.Reverse(be)
+		}
+		b = append(b, be...)
+	}
+	return b
+}
+
+// offsetBinary turns 8-bit signed samples into the unsigned ones WAV has.
+func offsetBinary(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, v := range b {
+		out[i] = v ^ 0x80
+	}
+	return out
+}
+
+func encodeFloats(samples []int16, bigEndian bool) []byte {
+	var order binary.AppendByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	var b []byte
+	for _, v := range samples {
+		b = order.AppendUint32(b, math.Float32bits(float32(v)/32768))
+	}
+	return b
+}
+
+func riff(id string, chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	b := chunk(binary.LittleEndian, id, body)
+	if id != "RIFF" {
+		binary.LittleEndian.PutUint32(b[4:8], unknownSize)
+	}
+	return b
+}
+
+// decodeBytes decodes a whole file held in memory.
+func decodeBytes(t *testing.T, file []byte) ([]int16, Format) {
+	t.Helper()
+	d, err := NewDecoder(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewDecoder: %v", err)
+	}
+	samples, err := ReadAll(d)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return samples, d.Format()
+}
+
+func TestWAV(t *testing.T) {
+	le := binary.LittleEndian
+	data16 := encodeInts(testSamples, 2, false)
+	ds64 := le.AppendUint64(le.AppendUint64(nil, 0), uint64(len(data16)))
+	ds64 = le.AppendUint64(ds64, uint64(len(testSamples)/2))
+	ds64 = le.AppendUint32(ds64, 0)
+	unsized := chunk(le, "data", data16)
+	le.PutUint32(unsized[4:8], unknownSize)
+	tests := []struct {
+		name   string
+		file   []byte
+		bits   int
+		float  bool
+		frames int64
+	}{
+		{"pcm16", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 2, 16, false)), chunk(le, "data", data16)), 16, false, 5},
+		{"pcm8", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 1, 8, false)), chunk(le, "data", offsetBinary(encodeInts(testSamples, 1, false)))), 8, false, 5},
+		{"pcm24", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 3, 24, false)), chunk(le, "data", encodeInts(testSamples, 3, false))), 24, false, 5},
+		{"pcm32", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 4, 32, false)), chunk(le, "data", encodeInts(testSamples, 4, false))), 32, false, 5},
+		{"float32", riff("RIFF", chunk(le, "fmt ", wavFmt(waveFloat, 2, 44100, 4, 32, false)), chunk(le, "data", encodeFloats(testSamples, false))), 32, true, 5},
+		{"extensible 24 in 32", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 4, 24, true)), chunk(le, "data", encodeInts(testSamples, 4, false))), 24, false, 5},
+		{"odd chunk before data", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 2, 16, false)), chunk(le, "LIST", []byte("odd")), chunk(le, "data", data16)), 16, false, 5},
+		{"rf64", riff("RF64", chunk(le, "ds64", ds64), chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 2, 16, false)), unsized), 16, false, 5},
+		{"bw64", riff("BW64", chunk(le, "ds64", ds64), chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 2, 16, false)), unsized), 16, false, 5},
+		{"streamed without a size", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 2, 16, false)), unsized), 16, false, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, f := decodeBytes(t, tt.file)
+			want := Format{Rate: 44100, Channels: 2, Bits: tt.bits, Float: tt.float, Frames: tt.frames}
+			if f != want {
+				t.Errorf("format %+v, want %+v", f, want)
+			}
+			if !slices.Equal(got, testSamples) {
+				t.Errorf("samples %v, want %v", got, testSamples)
+			}
+		})
+	}
+}
+
+func TestWAVFloat(t *testing.T) {
+	le := binary.LittleEndian
+	file := riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 3, 24, false)), chunk(le, "data", encodeInts(testSamples, 3, false)))
+	d, err := NewDecoder(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]float32, 64)
+	n, _ := d.(FloatDecoder).ReadFloat(buf)
+	for i, v := range buf[:n] {
+		// the low byte is kept, not cut to 16 bits
+		want := float32(int32(testSamples[i])<<16|0x7f<<8) / (1 << 31)
+		if v != want {
+			t.Errorf("sample %d: %v, want %v", i, v, want)
+		}
+	}
+	if n != len(testSamples) {
+		t.Errorf("read %d samples, want %d", n, len(testSamples))
+	}
+}
+
+func TestWAVLength(t *testing.T) {
+	le := binary.LittleEndian
+	fmt16 := chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 2, 16, false))
+	data16 := encodeInts(testSamples, 2, false)
+	sized := func(size uint32, data []byte) []byte {
+		c := chunk(le, "data", data)
+		le.PutUint32(c[4:8], size)
+		return riff("RIFF", fmt16, c)
+	}
+	tests := []struct {
+		name   string
+		file   []byte
+		pipe   bool // the reader can't say how long it is
+		frames int64
+		want   []int16
+	}{
+		{"sox placeholder in a file", sized(soxSize, data16), false, 5, testSamples},
+		{"sox placeholder on a pipe", sized(soxSize, data16), true, -1, testSamples},
+		{"no size on a pipe", sized(unknownSize, data16), true, -1, testSamples},
+		{"cut off", sized(uint32(len(data16)), data16[:12]), false, 3, testSamples[:6]},
+		{"cut off mid-frame", sized(uint32(len(data16)), data16[:14]), false, 3, testSamples[:6]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r io.Reader = bytes.NewReader(tt.file)
+			if tt.pipe {
+				r = struct{ io.Reader }{r}
+			}
+			d, err := NewDecoder(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f := d.Format(); f.Frames != tt.frames {
+				t.Errorf("%d frames, want %d", f.Frames, tt.frames)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("samples %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWAVRejects(t *testing.T) {
+	le := binary.LittleEndian
+	fmt16 := chunk(le, "fmt ", wavFmt(wavePCM, 2, 44100, 2, 16, false))
+	huge := chunk(le, "fmt ", nil)
+	le.PutUint32(huge[4:8], 0x7fffffff)
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"data before fmt", riff("RIFF", chunk(le, "data", []byte{0, 0, 0, 0}), fmt16)},
+		{"no data", riff("RIFF", fmt16)},
+		{"huge fmt", riff("RIFF", huge)},
+		{"no channels", riff("RIFF", chunk(le, "fmt ", wavFmt(wavePCM, 0, 44100, 2, 16, false)))},
+		{"adpcm", riff("RIFF", chunk(le, "fmt ", wavFmt(2, 2, 44100, 2, 16, false)))},
+		{"not wave", []byte("RIFF\x04\x00\x00\x00AVI LIST")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDecoder(bytes.NewReader(tt.file)); !errors.Is(err, ErrFormat) {
+				t.Errorf("err %v, want ErrFormat", err)
+			}
+		})
+	}
+}